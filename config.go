@@ -14,6 +14,31 @@
 
 package streamxml
 
+// CommentMode controls how <!-- ... --> comments are handled
+type CommentMode int
+
+const (
+	// CommentDrop removes comments from the output
+	CommentDrop CommentMode = iota
+	// CommentText keeps comments verbatim as text (or element content)
+	CommentText
+	// CommentNode captures comments as ASTNodeComment nodes at the top level,
+	// or in XmlNode.Comments inside an element
+	CommentNode
+)
+
+// StrayCloseMode controls how a closing tag without a matching open element is handled
+type StrayCloseMode int
+
+const (
+	// StrayCloseDrop silently removes stray closing tags
+	StrayCloseDrop StrayCloseMode = iota
+	// StrayCloseText keeps stray closing tags verbatim as text
+	StrayCloseText
+	// StrayCloseWarn removes stray closing tags and records an ErrStrayClosingTag warning
+	StrayCloseWarn
+)
+
 // ParserConfig holds configuration options for the StreamXmlParser
 type ParserConfig struct {
 	// MaxDepth limits the maximum nesting depth of XML elements (default: 100)
@@ -29,6 +54,117 @@ type ParserConfig struct {
 
 	// BufferCleanupThreshold determines when to cleanup consumed buffer data in bytes (default: 1KB)
 	BufferCleanupThreshold int
+
+	// CommentMode controls how comments outside any element are handled (default: CommentDrop)
+	CommentMode CommentMode
+
+	// ElementCommentMode controls how comments inside an element's content are handled (default: CommentDrop)
+	ElementCommentMode CommentMode
+
+	// PreserveComments keeps comments outside any element as ASTNodeComment
+	// nodes, the same as CommentMode CommentNode, which it overrides
+	PreserveComments bool
+
+	// WhitespaceNodes classifies top-level text that is entirely whitespace, such
+	// as the newlines between elements, as ASTNodeWhitespace instead of ASTNodeText.
+	// GetText still includes it.
+	WhitespaceNodes bool
+
+	// ContentTextChunks makes OnTextChunk also report text content inside elements
+	ContentTextChunks bool
+
+	// StrayCloseMode controls how closing tags outside any element are handled (default: StrayCloseDrop)
+	StrayCloseMode StrayCloseMode
+
+	// MaxStoredAttributes limits how many attributes are stored per node; the
+	// rest of the tag is still parsed and XmlNode.AttributesTruncated is set
+	// (default: 0, no limit)
+	MaxStoredAttributes int
+
+	// WarnOnEmptyAttributeValue records a warning when an attribute has nothing
+	// after '=' (e.g. name= type="x"), as opposed to an explicit name=""
+	WarnOnEmptyAttributeValue bool
+
+	// DoubledBracketEscape treats "<<" and ">>" in text and content as literal
+	// '<' and '>' instead of tag delimiters
+	DoubledBracketEscape bool
+
+	// RespectCodeFences treats markdown fenced code blocks (``` at the start of a
+	// line, up to the matching closing fence) outside any element as text
+	RespectCodeFences bool
+
+	// NormalizeEmptyElements reports <name/> like <name></name>, with
+	// SelfClosing false and empty Content. Content attributes set with
+	// SetContentAttribute then no longer apply.
+	NormalizeEmptyElements bool
+
+	// RetainInput keeps a copy of all appended data so Reparse works after
+	// buffer cleanup, at the cost of memory proportional to the whole stream
+	RetainInput bool
+
+	// RetainRawInput keeps a copy of all appended data so RawInput returns it
+	// after buffer cleanup, at the cost of memory proportional to the whole stream
+	RetainRawInput bool
+
+	// PreserveFormatting makes XmlNode.Marshal reproduce opening tags byte-for-byte,
+	// including whitespace between attributes, around '=' and the original quotes
+	PreserveFormatting bool
+
+	// TrimAttributeValues removes leading and trailing whitespace from attribute
+	// values, including quoted ones such as name=" search "
+	TrimAttributeValues bool
+
+	// TrackAppendSpans records in XmlNode.AppendSpan which Append calls
+	// contributed to each top-level node
+	TrackAppendSpans bool
+
+	// StripDirectiveSigil removes the leading '@' from the names of directive
+	// elements such as <@set/>, which then report Name "set" with Directive set.
+	// Per-element options use the reported name.
+	StripDirectiveSigil bool
+
+	// MaxTokensPerAppend bounds the tokens processed by a single Append so one
+	// huge chunk cannot monopolize the caller; the rest is processed by later
+	// calls to Append or Drain, or by Finalize. A tag is always processed
+	// whole, so a call may exceed the limit by up to one tag's tokens
+	// (default: 0, no limit)
+	MaxTokensPerAppend int
+
+	// LenientLimits records nesting limit violations (MaxDepth and limits set
+	// with SetElementMaxDepth) as warnings instead of returning
+	// ErrMaxDepthExceeded; the too-deep elements remain part of their
+	// top-level element's content and parsing continues
+	LenientLimits bool
+
+	// LenientTags drops opening and self-closing tags whose element name is not
+	// valid, such as <=x> or <1a>, recording an ErrMalformedTag warning, so they
+	// do not open an element that swallows the rest of the stream
+	LenientTags bool
+
+	// StripZeroWidth removes zero-width characters (U+200B, U+200C, U+200D and
+	// U+FEFF) from the stream before tokenization, so they neither break element
+	// name matching nor end up in text and content
+	StripZeroWidth bool
+
+	// RequireSpaceBeforeSelfClose treats self-closing tags without whitespace
+	// before "/>", such as <tool/>, as malformed: they are dropped with a
+	// warning under LenientTags and kept as text otherwise. <tool /> is valid.
+	RequireSpaceBeforeSelfClose bool
+
+	// DecodeEntities decodes the predefined XML entities (&amp;, &lt;, &gt;,
+	// &quot;, &apos;) and numeric character references such as &#169; in text,
+	// element content and attribute values. Unknown or malformed references are
+	// kept as written. Nested tags within content and InnerXML stay as written.
+	DecodeEntities bool
+
+	// ChildNodes builds nested elements as XmlNode.Children instead of
+	// reconstructing their tags in the parent's content. Content then holds
+	// only the element's own text, without that of its children.
+	ChildNodes bool
+
+	// ReadChunkSize is the size in bytes of the reads StreamXmlParser.ReadFrom
+	// makes, each appended as it arrives (default: 0, 4KB)
+	ReadChunkSize int
 }
 
 // DefaultConfig returns the default parser configuration
@@ -52,5 +188,22 @@ func (c ParserConfig) Validate() error {
 	if c.BufferCleanupThreshold < 0 {
 		return ErrInvalidConfiguration
 	}
+	if c.MaxStoredAttributes < 0 || c.MaxTokensPerAppend < 0 || c.ReadChunkSize < 0 {
+		return ErrInvalidConfiguration
+	}
+	if !c.CommentMode.valid() || !c.ElementCommentMode.valid() {
+		return ErrInvalidConfiguration
+	}
+	if !c.StrayCloseMode.valid() {
+		return ErrInvalidConfiguration
+	}
 	return nil
 }
+
+func (m CommentMode) valid() bool {
+	return m >= CommentDrop && m <= CommentNode
+}
+
+func (m StrayCloseMode) valid() bool {
+	return m >= StrayCloseDrop && m <= StrayCloseWarn
+}
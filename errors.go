@@ -14,7 +14,10 @@
 
 package streamxml
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // Error definitions for the parser
 var (
@@ -26,4 +29,89 @@ var (
 
 	// ErrInvalidConfiguration is returned when parser configuration is invalid
 	ErrInvalidConfiguration = errors.New("invalid parser configuration")
+
+	// ErrUnterminatedAttributeValue is reported when an attribute quote is still open at end of stream
+	ErrUnterminatedAttributeValue = errors.New("unterminated attribute value")
+
+	// ErrEmptyAttributeValue is reported when an attribute has no value after '='
+	ErrEmptyAttributeValue = errors.New("empty attribute value")
+
+	// ErrStrayClosingTag is reported when a closing tag has no matching open element
+	ErrStrayClosingTag = errors.New("stray closing tag")
+
+	// ErrInputNotRetained is returned by Reparse when part of the input was discarded
+	// and ParserConfig.RetainInput is not set
+	ErrInputNotRetained = errors.New("input not retained")
+
+	// ErrContextNotAtTopLevel is returned by PushContext when the parser is inside an element or tag
+	ErrContextNotAtTopLevel = errors.New("context can only be pushed at the top level")
+
+	// ErrMissingRequiredAttribute is returned when a completed element lacks an
+	// attribute registered with SetRequiredAttributes
+	ErrMissingRequiredAttribute = errors.New("missing required attribute")
+
+	// ErrStreamTerminated is returned by Append after the element set with
+	// SetTerminatorElement has ended the stream
+	ErrStreamTerminated = errors.New("stream terminated")
+
+	// ErrMalformedTag is reported when ParserConfig.LenientTags drops a tag with an invalid element name
+	ErrMalformedTag = errors.New("malformed tag")
+
+	// ErrUnknownElement is returned by ValidateAgainst for an element the schema does not declare
+	ErrUnknownElement = errors.New("unknown element")
+
+	// ErrContentRequired is returned by ValidateAgainst when an element that requires content has none
+	ErrContentRequired = errors.New("element content required")
+
+	// ErrContentForbidden is returned by ValidateAgainst when an element that forbids content has some
+	ErrContentForbidden = errors.New("element content forbidden")
 )
+
+// Warning describes a recoverable problem encountered while parsing
+type Warning struct {
+	// Err identifies the kind of problem (one of the Err* values)
+	Err error
+	// Position is the buffer position where the problem starts
+	Position int
+	// Detail holds the offending input, if any
+	Detail string
+}
+
+// Error implements the error interface
+func (w Warning) Error() string {
+	if w.Detail == "" {
+		return fmt.Sprintf("%v at position %d", w.Err, w.Position)
+	}
+	return fmt.Sprintf("%v at position %d: %q", w.Err, w.Position, w.Detail)
+}
+
+// Unwrap returns the underlying error so errors.Is works on warnings
+func (w Warning) Unwrap() error {
+	return w.Err
+}
+
+// TextifyReason says why a tag was kept as text (see TextifiedTags)
+type TextifyReason int
+
+const (
+	// TextifyDisallowed marks a tag whose element is not in the allowed elements
+	TextifyDisallowed TextifyReason = iota + 1
+	// TextifyMalformed marks a '<' that did not start a complete tag naming an
+	// element, such as in "a < b" or a tag cut off at end of stream
+	TextifyMalformed
+	// TextifyStrayClose marks a closing tag without an open element, kept as
+	// text by StrayCloseText
+	TextifyStrayClose
+	// TextifyUnvalidated marks a tag rejected by the validator set with SetTagValidator
+	TextifyUnvalidated
+)
+
+// TextifiedTag records a tag that was kept as text instead of becoming an element
+type TextifiedTag struct {
+	// Name is the element name as written, if the tag has one
+	Name string
+	// Reason says why the tag was kept as text
+	Reason TextifyReason
+	// Position is the buffer position where the tag starts
+	Position int
+}
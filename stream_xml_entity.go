@@ -0,0 +1,88 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxEntityNameLen bounds the entity names that streaming text is held back
+// for, enough for every predefined entity and character reference up to
+// "#x10FFFF" or "#1114111"
+const maxEntityNameLen = 8
+
+// decodeEntities replaces the predefined XML entities (&lt; &gt; &amp; &quot; &apos;)
+// and numeric character references in s. Unknown or malformed references are
+// kept as written.
+func decodeEntities(s string) string {
+	if !strings.Contains(s, "&") {
+		return s
+	}
+
+	var out strings.Builder
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			break
+		}
+		out.WriteString(s[:i])
+		s = s[i:]
+
+		semi := strings.IndexByte(s, ';')
+		if semi < 0 {
+			break
+		}
+		if r, ok := entityRune(s[1:semi]); ok {
+			out.WriteRune(r)
+			s = s[semi+1:]
+		} else {
+			out.WriteByte('&')
+			s = s[1:]
+		}
+	}
+	out.WriteString(s)
+	return out.String()
+}
+
+// entityRune returns the character for an entity name such as "lt" or "#x41"
+func entityRune(name string) (rune, bool) {
+	switch name {
+	case "lt":
+		return '<', true
+	case "gt":
+		return '>', true
+	case "amp":
+		return '&', true
+	case "quot":
+		return '"', true
+	case "apos":
+		return '\'', true
+	}
+
+	if len(name) < 2 || name[0] != '#' {
+		return 0, false
+	}
+	digits, base := name[1:], 10
+	if digits[0] == 'x' || digits[0] == 'X' {
+		digits, base = digits[1:], 16
+	}
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || !utf8.ValidRune(rune(n)) {
+		return 0, false
+	}
+	return rune(n), true
+}
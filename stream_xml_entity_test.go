@@ -0,0 +1,42 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"testing"
+)
+
+// TestDecodeEntities tests predefined entities, numeric references and malformed input
+func TestDecodeEntities(t *testing.T) {
+	tests := map[string]string{
+		"plain":                    "plain",
+		"a &lt; b &gt; c":          "a < b > c",
+		"&quot;x&quot; &apos;y'":   "\"x\" 'y'",
+		"&amp;lt;":                 "&lt;",
+		"&#65;&#x42;&#X43;":        "ABC",
+		"&#x1F600;":                "😀",
+		"R&D":                      "R&D",
+		"a & b; c":                 "a & b; c",
+		"&nbsp;&unknown;":          "&nbsp;&unknown;",
+		"&#;&#x;&#xZZ;&#99999999;": "&#;&#x;&#xZZ;&#99999999;",
+		"trailing &amp":            "trailing &amp",
+	}
+
+	for input, expected := range tests {
+		if got := decodeEntities(input); got != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, got)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import "sync"
+
+// Event is a parse event delivered by StreamXmlParser.Events. It is one of
+// TextEvent, NodeStartEvent or NodeCompleteEvent.
+type Event interface {
+	isEvent()
+}
+
+// TextEvent carries a run of top-level text
+type TextEvent struct {
+	Text string
+}
+
+// NodeStartEvent reports a top-level node whose opening tag has completed.
+// Node is a copy taken at that moment; its content is still to come.
+type NodeStartEvent struct {
+	Node *XmlNode
+}
+
+// NodeCompleteEvent reports a top-level node that has completed, with a copy of the node
+type NodeCompleteEvent struct {
+	Node *XmlNode
+}
+
+func (TextEvent) isEvent()         {}
+func (NodeStartEvent) isEvent()    {}
+func (NodeCompleteEvent) isEvent() {}
+
+// eventStream queues events without bounds and forwards them to a channel
+// from its own goroutine, so the parser never blocks on a slow consumer. The
+// goroutine only runs while events are queued, so a parser that is dropped
+// without Finalize leaves nothing running once its events are received.
+type eventStream struct {
+	mu      sync.Mutex
+	queue   []Event
+	running bool
+	closed  bool
+	ch      chan Event
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{ch: make(chan Event)}
+}
+
+func (s *eventStream) push(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.queue = append(s.queue, event)
+	s.start()
+}
+
+// start runs the forwarding goroutine unless it is running; s.mu must be held
+func (s *eventStream) start() {
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+}
+
+func (s *eventStream) run() {
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.running = false
+			if s.closed {
+				close(s.ch)
+			}
+			s.mu.Unlock()
+			return
+		}
+		event := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		s.ch <- event
+	}
+}
+
+// Node implements Sink
+func (s *eventStream) Node(node *XmlNode) {
+	s.push(NodeCompleteEvent{Node: node.clone()})
+}
+
+// Text implements Sink
+func (s *eventStream) Text(text string) {
+	s.push(TextEvent{Text: text})
+}
+
+// Done implements Sink; the channel is closed once queued events are delivered
+func (s *eventStream) Done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.start()
+}
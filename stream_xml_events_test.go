@@ -0,0 +1,176 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEventsSequence tests consuming events for a mixed stream
+func TestEventsSequence(t *testing.T) {
+	parser := NewStreamXmlParser()
+	events := parser.Events()
+
+	done := make(chan []string)
+	go func() {
+		var seen []string
+		for event := range events {
+			switch e := event.(type) {
+			case TextEvent:
+				seen = append(seen, "text:"+e.Text)
+			case NodeStartEvent:
+				seen = append(seen, "start:"+e.Node.Name)
+			case NodeCompleteEvent:
+				seen = append(seen, fmt.Sprintf("complete:%s=%s", e.Node.Name, e.Node.Content))
+			}
+		}
+		done <- seen
+	}()
+
+	parser.Append("Hi <to")
+	parser.Append("ol>args</tool> mid <ping/>")
+	parser.Append(" bye")
+	parser.Finalize()
+
+	select {
+	case seen := <-done:
+		expected := []string{
+			"text:Hi ",
+			"start:tool",
+			"complete:tool=args",
+			"text: mid ",
+			"start:ping",
+			"complete:ping=",
+			"text: bye",
+		}
+		if strings.Join(seen, "|") != strings.Join(expected, "|") {
+			t.Errorf("expected events %q, got %q", expected, seen)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the events channel to close after Finalize")
+	}
+}
+
+// TestEventsDoNotBlockAppend tests that Append does not wait for the consumer
+func TestEventsDoNotBlockAppend(t *testing.T) {
+	parser := NewStreamXmlParser()
+	events := parser.Events()
+	if parser.Events() != events {
+		t.Errorf("expected Events to return the same channel")
+	}
+
+	for i := 0; i < 100; i++ {
+		parser.Append("<a>x</a>")
+	}
+	parser.Finalize()
+
+	count := 0
+	for range events {
+		count++
+	}
+	if count != 200 {
+		t.Errorf("expected 200 events, got %d", count)
+	}
+}
+
+// TestEventsAfterFinalize tests that the channel is closed when requested after Finalize
+func TestEventsAfterFinalize(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Finalize()
+
+	select {
+	case _, ok := <-parser.Events():
+		if ok {
+			t.Errorf("expected no events")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a closed channel")
+	}
+}
+
+// TestEventsClosedWhenFinalizeFails tests that the channel is closed when
+// processing the rest of the input fails during Finalize
+func TestEventsClosedWhenFinalizeFails(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxDepth = 2
+	parser := NewStreamXmlParserWithConfig(config)
+	events := parser.Events()
+
+	parser.Pause()
+	parser.Append("<a><b><c>x")
+	if err := parser.Finalize(); !errors.Is(err, ErrMaxDepthExceeded) {
+		t.Fatalf("expected ErrMaxDepthExceeded, got %v", err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("expected the channel to close after a failed Finalize")
+		}
+	}
+}
+
+// TestEventsCarryCopies tests that event nodes do not change as parsing continues
+func TestEventsCarryCopies(t *testing.T) {
+	parser := NewStreamXmlParser()
+	events := parser.Events()
+	parser.Append("<tool>a")
+
+	start, ok := (<-events).(NodeStartEvent)
+	if !ok {
+		t.Fatal("expected a NodeStartEvent")
+	}
+	parser.Append("bc</tool>")
+	complete, ok := (<-events).(NodeCompleteEvent)
+	if !ok {
+		t.Fatal("expected a NodeCompleteEvent")
+	}
+	if start.Node.Content != "" || !start.Node.Partial {
+		t.Errorf("expected the start event to keep the node as it was, got %+v", start.Node)
+	}
+	if complete.Node.Content != "abc" || complete.Node.Partial {
+		t.Errorf("expected the complete node, got %+v", complete.Node)
+	}
+}
+
+// TestEventsGoroutineStops tests that no goroutine stays behind once all
+// events are received, even without Finalize
+func TestEventsGoroutineStops(t *testing.T) {
+	before := runtime.NumGoroutine()
+	parser := NewStreamXmlParser()
+	events := parser.Events()
+	parser.Append("hi <tool/>")
+	for range 3 {
+		<-events
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the event goroutine to stop, have %d goroutines, started with %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
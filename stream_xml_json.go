@@ -0,0 +1,313 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+type jsonState int
+
+const (
+	jsonValue      jsonState = iota // expecting a value
+	jsonValueOrEnd                  // after '[', expecting a value or ']'
+	jsonKeyOrEnd                    // after '{', expecting a key or '}'
+	jsonKey                         // after ',' in an object, expecting a key
+	jsonColon                       // after a key, expecting ':'
+	jsonAfterValue                  // after a value, expecting ',', a closing bracket or the end
+	jsonString                      // inside a string
+	jsonEscape                      // after '\' inside a string
+	jsonUnicode                     // inside a \uXXXX escape
+	jsonLiteral                     // inside true, false or null
+	jsonNumber                      // inside a number
+)
+
+type jsonNumberState int
+
+const (
+	numSign      jsonNumberState = iota // after '-'
+	numZero                             // after a leading '0'
+	numInt                              // in integer digits
+	numDot                              // after '.'
+	numFrac                             // in fraction digits
+	numExp                              // after 'e' or 'E'
+	numExpSign                          // after the exponent sign
+	numExpDigits                        // in exponent digits
+)
+
+// jsonChecker is an incremental JSON well-formedness checker.
+// It consumes input in arbitrary pieces and only tracks syntax, never values.
+type jsonChecker struct {
+	stack   []byte // open containers, '{' or '['
+	state   jsonState
+	key     bool   // the current string is an object key
+	hex     int    // remaining hex digits of a \u escape
+	literal string // remaining bytes of a literal
+	number  jsonNumberState
+	failed  bool
+}
+
+// feed consumes the next piece of input
+func (c *jsonChecker) feed(data string) {
+	for i := 0; i < len(data) && !c.failed; i++ {
+		c.step(data[i])
+	}
+}
+
+// complete reports whether the input so far is exactly one well-formed JSON value
+func (c *jsonChecker) complete() bool {
+	if c.failed || len(c.stack) > 0 {
+		return false
+	}
+	if c.state == jsonNumber {
+		return c.number.terminal()
+	}
+	return c.state == jsonAfterValue
+}
+
+func (c *jsonChecker) step(ch byte) {
+	if c.state == jsonNumber {
+		if c.stepNumber(ch) {
+			return
+		}
+		if !c.number.terminal() {
+			c.failed = true
+			return
+		}
+		c.state = jsonAfterValue
+	}
+
+	switch c.state {
+	case jsonValue, jsonValueOrEnd:
+		if isJSONSpace(ch) {
+			return
+		}
+		if ch == ']' && c.state == jsonValueOrEnd {
+			c.stack = c.stack[:len(c.stack)-1]
+			c.state = jsonAfterValue
+			return
+		}
+		c.startValue(ch)
+
+	case jsonKeyOrEnd, jsonKey:
+		if isJSONSpace(ch) {
+			return
+		}
+		if ch == '}' && c.state == jsonKeyOrEnd {
+			c.stack = c.stack[:len(c.stack)-1]
+			c.state = jsonAfterValue
+			return
+		}
+		if ch != '"' {
+			c.failed = true
+			return
+		}
+		c.state = jsonString
+		c.key = true
+
+	case jsonColon:
+		if isJSONSpace(ch) {
+			return
+		}
+		if ch != ':' {
+			c.failed = true
+			return
+		}
+		c.state = jsonValue
+
+	case jsonString:
+		switch {
+		case ch == '\\':
+			c.state = jsonEscape
+		case ch == '"':
+			if c.key {
+				c.state = jsonColon
+			} else {
+				c.state = jsonAfterValue
+			}
+		case ch < 0x20:
+			c.failed = true
+		}
+
+	case jsonEscape:
+		switch ch {
+		case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+			c.state = jsonString
+		case 'u':
+			c.state = jsonUnicode
+			c.hex = 4
+		default:
+			c.failed = true
+		}
+
+	case jsonUnicode:
+		if !isHexDigit(ch) {
+			c.failed = true
+			return
+		}
+		c.hex--
+		if c.hex == 0 {
+			c.state = jsonString
+		}
+
+	case jsonLiteral:
+		if ch != c.literal[0] {
+			c.failed = true
+			return
+		}
+		c.literal = c.literal[1:]
+		if c.literal == "" {
+			c.state = jsonAfterValue
+		}
+
+	case jsonAfterValue:
+		if isJSONSpace(ch) {
+			return
+		}
+		if len(c.stack) == 0 {
+			// Trailing data after a complete value
+			c.failed = true
+			return
+		}
+		top := c.stack[len(c.stack)-1]
+		switch {
+		case ch == ',' && top == '{':
+			c.state = jsonKey
+		case ch == ',' && top == '[':
+			c.state = jsonValue
+		case ch == '}' && top == '{', ch == ']' && top == '[':
+			c.stack = c.stack[:len(c.stack)-1]
+		default:
+			c.failed = true
+		}
+	}
+}
+
+// startValue begins a new value with its first byte
+func (c *jsonChecker) startValue(ch byte) {
+	switch {
+	case ch == '{':
+		c.stack = append(c.stack, '{')
+		c.state = jsonKeyOrEnd
+	case ch == '[':
+		c.stack = append(c.stack, '[')
+		c.state = jsonValueOrEnd
+	case ch == '"':
+		c.state = jsonString
+		c.key = false
+	case ch == 't':
+		c.state = jsonLiteral
+		c.literal = "rue"
+	case ch == 'f':
+		c.state = jsonLiteral
+		c.literal = "alse"
+	case ch == 'n':
+		c.state = jsonLiteral
+		c.literal = "ull"
+	case ch == '-':
+		c.state = jsonNumber
+		c.number = numSign
+	case ch == '0':
+		c.state = jsonNumber
+		c.number = numZero
+	case ch >= '1' && ch <= '9':
+		c.state = jsonNumber
+		c.number = numInt
+	default:
+		c.failed = true
+	}
+}
+
+// stepNumber advances the number state, returning false if ch does not belong to the number
+func (c *jsonChecker) stepNumber(ch byte) bool {
+	digit := ch >= '0' && ch <= '9'
+	exp := ch == 'e' || ch == 'E'
+
+	switch c.number {
+	case numSign:
+		switch {
+		case ch == '0':
+			c.number = numZero
+		case digit:
+			c.number = numInt
+		default:
+			c.failed = true
+		}
+		return true
+	case numZero:
+		switch {
+		case ch == '.':
+			c.number = numDot
+		case exp:
+			c.number = numExp
+		default:
+			return false
+		}
+		return true
+	case numInt:
+		switch {
+		case digit:
+		case ch == '.':
+			c.number = numDot
+		case exp:
+			c.number = numExp
+		default:
+			return false
+		}
+		return true
+	case numDot:
+		if !digit {
+			c.failed = true
+		}
+		c.number = numFrac
+		return true
+	case numFrac:
+		switch {
+		case digit:
+		case exp:
+			c.number = numExp
+		default:
+			return false
+		}
+		return true
+	case numExp:
+		switch {
+		case ch == '+' || ch == '-':
+			c.number = numExpSign
+		case digit:
+			c.number = numExpDigits
+		default:
+			c.failed = true
+		}
+		return true
+	case numExpSign:
+		if !digit {
+			c.failed = true
+		}
+		c.number = numExpDigits
+		return true
+	default:
+		return digit
+	}
+}
+
+// terminal reports whether a number may end in this state
+func (s jsonNumberState) terminal() bool {
+	return s == numZero || s == numInt || s == numFrac || s == numExpDigits
+}
+
+func isJSONSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+func isHexDigit(ch byte) bool {
+	return (ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
@@ -0,0 +1,41 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestJSONCheckerMatchesEncodingJSON tests the incremental checker against encoding/json
+func TestJSONCheckerMatchesEncodingJSON(t *testing.T) {
+	inputs := []string{
+		`{}`, `[]`, ` {"a": [1, -2.5e+3, true, false, null, "x\"é"]} `,
+		`0`, `-0.1`, `12`, `"str"`, `[[],{}]`,
+		``, `{`, `{"a"}`, `{"a":1,}`, `[1,]`, `01`, `1.`, `-`, `1e`, `tru`, `nul1`,
+		`{"a":1} x`, `"\x"`, `"\u12g4"`, `{'a':1}`, `[1 2]`,
+	}
+
+	for _, input := range inputs {
+		// Feed byte by byte to exercise every split point
+		checker := &jsonChecker{}
+		for i := 0; i < len(input); i++ {
+			checker.feed(input[i : i+1])
+		}
+		if got, want := checker.complete(), json.Valid([]byte(input)); got != want {
+			t.Errorf("%q: expected valid=%v, got %v", input, want, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+// Names of the counters returned by Metrics
+const (
+	MetricBytesAppended  = "bytes_appended"  // bytes accepted by Append
+	MetricNodesStarted   = "nodes_started"   // top-level elements opened
+	MetricNodesCompleted = "nodes_completed" // top-level elements closed
+	MetricErrors         = "errors"          // errors returned while appending or processing input
+	MetricRecoveries     = "recoveries"      // recoverable problems recorded as warnings
+)
+
+// parserMetrics holds the counters behind Metrics
+type parserMetrics struct {
+	bytesAppended  int
+	nodesStarted   int
+	nodesCompleted int
+	errors         int
+	recoveries     int
+}
+
+// Metrics returns a snapshot of the parser's counters keyed by the Metric*
+// names, for export to a metrics system such as a Prometheus collector.
+// Counters only increase until Reset sets them back to zero.
+// This method is thread-safe.
+func (p *StreamXmlParser) Metrics() map[string]float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return map[string]float64{
+		MetricBytesAppended:  float64(p.metrics.bytesAppended),
+		MetricNodesStarted:   float64(p.metrics.nodesStarted),
+		MetricNodesCompleted: float64(p.metrics.nodesCompleted),
+		MetricErrors:         float64(p.metrics.errors),
+		MetricRecoveries:     float64(p.metrics.recoveries),
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestMetrics tests counter values after a known stream with an induced error
+func TestMetrics(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxDepth = 2
+	config.WarnOnEmptyAttributeValue = true
+	parser := NewStreamXmlParserWithConfig(config)
+
+	chunks := []string{"hi <a>1</a>", "<b x= />", "<c><d>"}
+	for _, chunk := range chunks {
+		if err := parser.Append(chunk); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := parser.Append("<e>"); !errors.Is(err, ErrMaxDepthExceeded) {
+		t.Fatalf("expected ErrMaxDepthExceeded, got %v", err)
+	}
+
+	expected := map[string]float64{
+		MetricBytesAppended:  28,
+		MetricNodesStarted:   3,
+		MetricNodesCompleted: 2,
+		MetricErrors:         1,
+		MetricRecoveries:     1,
+	}
+	metrics := parser.Metrics()
+	if len(metrics) != len(expected) {
+		t.Errorf("expected %d metrics, got %v", len(expected), metrics)
+	}
+	for name, value := range expected {
+		if metrics[name] != value {
+			t.Errorf("%s: expected %v, got %v", name, value, metrics[name])
+		}
+	}
+
+	// The snapshot does not change with later input
+	parser.Append("x")
+	if metrics[MetricBytesAppended] != 28 {
+		t.Errorf("expected snapshot to be unchanged")
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// clone returns a snapshot of the node that shares no mutable state with it.
+// A copied child keeps its Path but not its enclosing elements.
+func (n *XmlNode) clone() *XmlNode {
+	node := n.cloneTree()
+	if n.parent != nil {
+		node.path = n.Path()
+		node.parent = nil
+	}
+	return node
+}
+
+// cloneTree copies the node and its children
+func (n *XmlNode) cloneTree() *XmlNode {
+	node := *n
+	node.Attributes = maps.Clone(n.Attributes)
+	node.OrderedAttributes = slices.Clone(n.OrderedAttributes)
+	node.Comments = slices.Clone(n.Comments)
+	if n.Children != nil {
+		node.Children = make([]*XmlNode, len(n.Children))
+		for i, child := range n.Children {
+			node.Children[i] = child.cloneTree()
+			node.Children[i].parent = &node
+		}
+	}
+	if n.JSONValid != nil {
+		valid := *n.JSONValid
+		node.JSONValid = &valid
+	}
+	node.jsonChecker = nil
+	node.textBuffers = nil
+	return &node
+}
+
+// ContentBytes returns the node content as bytes.
+// For elements registered via SetBase64Elements the content is base64-decoded
+// on demand; otherwise the raw content bytes are returned.
+func (n *XmlNode) ContentBytes() ([]byte, error) {
+	if !n.base64Content {
+		return []byte(n.Content), nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(n.Content))
+	if err != nil {
+		return nil, fmt.Errorf("decoding base64 content of <%s>: %w", n.Name, err)
+	}
+	return data, nil
+}
+
+// EffectiveContent returns the content that best represents the node.
+// For self-closing elements with a content attribute configured via
+// SetContentAttribute, it returns that attribute's value; otherwise Content.
+func (n *XmlNode) EffectiveContent() string {
+	if n.SelfClosing && n.contentAttribute != "" {
+		return n.Attributes[n.contentAttribute]
+	}
+	return n.Content
+}
+
+// AttributeValues returns every value of the named attribute in document order.
+// Unlike Attributes, which keeps the last value, repeated names are all kept.
+func (n *XmlNode) AttributeValues(key string) []string {
+	var values []string
+	for _, attr := range n.OrderedAttributes {
+		if attr.Name == key {
+			values = append(values, attr.Value)
+		}
+	}
+	return values
+}
+
+// AttrInt returns the named attribute parsed as a decimal integer. It reports
+// false if the attribute is missing or not an integer.
+func (n *XmlNode) AttrInt(key string) (int, bool) {
+	value, ok := n.Attributes[key]
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return i, true
+}
+
+// AttrBool returns the named attribute parsed with strconv.ParseBool ("true",
+// "false", "1", "0", ...). It reports false if the attribute is missing or not a boolean.
+func (n *XmlNode) AttrBool(key string) (bool, bool) {
+	value, ok := n.Attributes[key]
+	if !ok {
+		return false, false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, false
+	}
+	return b, true
+}
+
+// AttrFloat returns the named attribute parsed as a float64. It reports
+// false if the attribute is missing or not a number.
+func (n *XmlNode) AttrFloat(key string) (float64, bool) {
+	value, ok := n.Attributes[key]
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// attributeEscaper and contentEscaper escape attribute values and content for
+// Marshal; '&' is escaped along with the rest in a single pass
+var (
+	attributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;")
+	contentEscaper   = strings.NewReplacer("&", "&amp;", "<", "&lt;")
+)
+
+// Marshal renders the node as XML. Attributes are written in document order as
+// name="value"; with ParserConfig.PreserveFormatting the opening tag is
+// reproduced exactly as it was written. Partial nodes have no closing tag.
+// Attribute values and content are taken as plain text, so '&', '<' and, in
+// attribute values, '"' are escaped; the output parses back to the same values
+// with ParserConfig.DecodeEntities. Nested markup kept in Content is escaped
+// too, while children built with ParserConfig.ChildNodes are written as
+// elements. Use InnerXML for the content exactly as it was written.
+func (n *XmlNode) Marshal() string {
+	var out strings.Builder
+
+	out.WriteString("<")
+	out.WriteString(n.Name)
+	if n.preserveFormatting {
+		out.WriteString(n.RawAttributes)
+	} else {
+		for _, attr := range n.OrderedAttributes {
+			out.WriteString(" ")
+			out.WriteString(attr.Name)
+			out.WriteString("=\"")
+			out.WriteString(attributeEscaper.Replace(attr.Value))
+			out.WriteString("\"")
+		}
+	}
+
+	if n.SelfClosing {
+		out.WriteString("/>")
+		return out.String()
+	}
+	out.WriteString(">")
+	out.WriteString(n.withChildren(contentEscaper.Replace, (*XmlNode).Marshal))
+	if !n.Partial {
+		out.WriteString("</")
+		out.WriteString(n.Name)
+		out.WriteString(">")
+	}
+	return out.String()
+}
+
+// InnerXML returns the original bytes between the opening and closing tags,
+// with nested markup exactly as written. Unlike Content, nested tags are not
+// reconstructed from tokens. For a partial node it returns what has been
+// received so far, excluding any incomplete tag at the end.
+func (n *XmlNode) InnerXML() string {
+	return n.innerXML
+}
+
+// Path returns the slash-separated names of the node's enclosing elements and
+// its own, outermost first, e.g. "response/tool/arg". The path of a top-level
+// node is its name; children exist with ParserConfig.ChildNodes.
+func (n *XmlNode) Path() string {
+	var names []string
+	for node := n; node != nil; node = node.parent {
+		if node.path != "" {
+			names = append(names, node.path)
+			break
+		}
+		names = append(names, node.Name)
+	}
+	slices.Reverse(names)
+	return strings.Join(names, "/")
+}
+
+// Text returns the text directly inside the element, leaving out nested
+// elements together with their text, e.g. "a c" for "a <b>x</b>c". The text is
+// recorded as it is parsed, so CDATA sections contribute their body and, with
+// ParserConfig.DecodeEntities, entities are decoded without becoming markup.
+func (n *XmlNode) Text() string {
+	return n.text
+}
+
+// InnerText returns all text inside the element, including the text of nested
+// elements but no markup, e.g. "a xc" for "a <b>x</b>c". Like Text, it is
+// recorded as the element is parsed.
+func (n *XmlNode) InnerText() string {
+	return n.innerText
+}
+
+// withChildren returns the content, passed through text, with each child,
+// rendered by render, inserted where it appeared (see ParserConfig.ChildNodes)
+func (n *XmlNode) withChildren(text func(string) string, render func(child *XmlNode) string) string {
+	if len(n.Children) == 0 {
+		return text(n.Content)
+	}
+	var out strings.Builder
+	offset := 0
+	for _, child := range n.Children {
+		// Content may have been shortened after the child appeared, e.g. by SetContentUnwrap
+		end := min(max(child.childOffset, offset), len(n.Content))
+		out.WriteString(text(n.Content[offset:end]))
+		out.WriteString(render(child))
+		offset = end
+	}
+	out.WriteString(text(n.Content[offset:]))
+	return out.String()
+}
@@ -0,0 +1,484 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestContentBytesBase64 tests decoding base64 content of a registered element
+func TestContentBytesBase64(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.SetBase64Elements([]string{"blob"})
+
+	parser.Append("<blob>aGVsbG8g")
+	parser.Append("d29ybGQ=\n</blob>")
+
+	node, _ := parser.GetXmlNode()
+	if node == nil {
+		t.Fatal("expected a node")
+	}
+	data, err := node.ContentBytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("expected 'hello world', got '%s'", data)
+	}
+}
+
+// TestContentBytesInvalidBase64 tests that decode errors are surfaced
+func TestContentBytesInvalidBase64(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.SetBase64Elements([]string{"blob"})
+
+	parser.Append("<blob>not base64!</blob>")
+
+	node, _ := parser.GetXmlNode()
+	if node == nil {
+		t.Fatal("expected a node")
+	}
+	data, err := node.ContentBytes()
+	if err == nil {
+		t.Fatalf("expected decode error, got '%s'", data)
+	}
+}
+
+// TestContentBytesRaw tests that other elements return raw content bytes
+func TestContentBytesRaw(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.SetBase64Elements([]string{"blob"})
+
+	parser.Append("<tool>aGVsbG8=</tool>")
+
+	node, _ := parser.GetXmlNode()
+	if node == nil {
+		t.Fatal("expected a node")
+	}
+	data, err := node.ContentBytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "aGVsbG8=" {
+		t.Errorf("expected raw content 'aGVsbG8=', got '%s'", data)
+	}
+}
+
+// TestEffectiveContentSelfClosing tests reading content from an attribute of a self-closing tag
+func TestEffectiveContentSelfClosing(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.SetContentAttribute("call", "args")
+
+	parser.Append(`<call name="x" args='{"a":1}'/>`)
+
+	node, _ := parser.GetXmlNode()
+	if node == nil {
+		t.Fatal("expected a node")
+	}
+	if !node.SelfClosing {
+		t.Errorf("expected self-closing node")
+	}
+	if node.EffectiveContent() != `{"a":1}` {
+		t.Errorf("expected effective content '{\"a\":1}', got '%s'", node.EffectiveContent())
+	}
+}
+
+// TestEffectiveContentNormalElement tests that normal elements return their content
+func TestEffectiveContentNormalElement(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.SetContentAttribute("call", "args")
+
+	parser.Append(`<call name="x" args="ignored">{"b":2}</call>`)
+
+	node, _ := parser.GetXmlNode()
+	if node == nil {
+		t.Fatal("expected a node")
+	}
+	if node.SelfClosing {
+		t.Errorf("expected non self-closing node")
+	}
+	if node.EffectiveContent() != `{"b":2}` {
+		t.Errorf("expected effective content '{\"b\":2}', got '%s'", node.EffectiveContent())
+	}
+}
+
+// TestAttributeValuesRepeated tests list semantics for repeated attribute names
+func TestAttributeValuesRepeated(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append(`<tool arg="a" name="x" arg="b" `)
+	parser.Append(`arg='c'>body</tool>`)
+
+	node, _ := parser.GetXmlNode()
+	if node == nil {
+		t.Fatal("expected a node")
+	}
+	values := node.AttributeValues("arg")
+	expected := []string{"a", "b", "c"}
+	if len(values) != len(expected) {
+		t.Fatalf("expected %q, got %q", expected, values)
+	}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Errorf("value %d: expected '%s', got '%s'", i, expected[i], values[i])
+		}
+	}
+	if node.Attributes["arg"] != "c" {
+		t.Errorf("expected last-wins map value 'c', got '%s'", node.Attributes["arg"])
+	}
+	if len(node.AttributeValues("name")) != 1 || node.AttributeValues("missing") != nil {
+		t.Errorf("unexpected values for single or missing attribute")
+	}
+}
+
+// TestMarshalPreserveFormatting tests byte-identical round-tripping of unusually spaced tags
+func TestMarshalPreserveFormatting(t *testing.T) {
+	config := DefaultConfig()
+	config.PreserveFormatting = true
+
+	inputs := []string{
+		"<tool  name = \"search\"\ttype='x y' >query</tool>",
+		"<tool a=1   b =\"2\"/>",
+		"<tool\n  a='say \"hi\"'\n/>",
+		"<tool>plain</tool>",
+	}
+
+	for _, input := range inputs {
+		parser := NewStreamXmlParserWithConfig(config)
+		for i := 0; i < len(input); i += 4 {
+			parser.Append(input[i:min(i+4, len(input))])
+		}
+
+		node, _ := parser.GetXmlNode()
+		if node == nil {
+			t.Fatalf("%q: expected a node", input)
+		}
+		if out := node.Marshal(); out != input {
+			t.Errorf("expected %q, got %q", input, out)
+		}
+	}
+}
+
+// TestMarshalNormalized tests rendering without preserved formatting
+func TestMarshalNormalized(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("<tool  name = \"search\"\tmsg='say \"hi\"' >query</tool><ping a=1 />")
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	if out := nodes[0].Marshal(); out != `<tool name="search" msg="say &quot;hi&quot;">query</tool>` {
+		t.Errorf("unexpected output %q", out)
+	}
+	if out := nodes[1].Marshal(); out != `<ping a="1"/>` {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	// A value with both quote characters stays well-formed
+	node := &XmlNode{Name: "a", SelfClosing: true, OrderedAttributes: []Attribute{{Name: "q", Value: `say "it's"`}}}
+	if out := node.Marshal(); out != `<a q="say &quot;it's&quot;"/>` {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+// TestMarshalRoundTrip tests that values with characters significant in XML
+// parse back unchanged from the marshalled output
+func TestMarshalRoundTrip(t *testing.T) {
+	values := []string{`"`, "&quot;", `say "it's"`, "a & b", "&amp;", "x < y", "<b>", "a > b", "plain"}
+
+	config := DefaultConfig()
+	config.DecodeEntities = true
+	for _, value := range values {
+		node := &XmlNode{
+			Name:              "tool",
+			Attributes:        map[string]string{"q": value},
+			OrderedAttributes: []Attribute{{Name: "q", Value: value}},
+			Content:           value,
+		}
+		out := node.Marshal()
+
+		parser := NewStreamXmlParserWithConfig(config)
+		parser.Append(out)
+		parser.Finalize()
+		nodes, _ := parser.GetXmlNodes()
+		if len(nodes) != 1 {
+			t.Fatalf("%q: expected 1 node from %q, got %d", value, out, len(nodes))
+		}
+		if nodes[0].Attributes["q"] != value || nodes[0].Content != value || nodes[0].Partial {
+			t.Errorf("%q: expected the value back from %q, got %+v", value, out, nodes[0])
+		}
+		if again := nodes[0].Marshal(); again != out {
+			t.Errorf("%q: expected stable output %q, got %q", value, out, again)
+		}
+	}
+}
+
+// TestInnerXML tests that InnerXML returns the literal inner substring of nested content
+func TestInnerXML(t *testing.T) {
+	inner := "\n  <step  id='1' >a &amp; b</step>\n  <br/><!-- note -->1 < 2\n  <step id=\"2\">c</step >\n"
+	input := "before <plan kind=\"x\">" + inner + "</plan> after"
+
+	for _, size := range []int{len(input), 7, 3, 1} {
+		parser := NewStreamXmlParser()
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+
+		node, _ := parser.GetXmlNode()
+		if node == nil {
+			t.Fatalf("chunk size %d: expected a node", size)
+		}
+		if got := node.InnerXML(); got != inner {
+			t.Errorf("chunk size %d: expected %q, got %q", size, inner, got)
+		}
+		buffer := parser.tokenizer.GetBuffer()
+		if got := buffer[node.InnerStartPos:node.EndPos]; got != inner {
+			t.Errorf("chunk size %d: expected positions to span %q, got %q", size, inner, got)
+		}
+	}
+}
+
+// TestInnerXMLPartial tests InnerXML while the element is still streaming
+func TestInnerXMLPartial(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("<plan><step a = '1'>x</st")
+
+	node, _ := parser.GetXmlNode()
+	if got := node.InnerXML(); got != "<step a = '1'>x" {
+		t.Errorf("expected %q, got %q", "<step a = '1'>x", got)
+	}
+
+	parser.Append("ep></plan>")
+	node, _ = parser.GetXmlNode()
+	if got := node.InnerXML(); got != "<step a = '1'>x</step>" {
+		t.Errorf("expected %q, got %q", "<step a = '1'>x</step>", got)
+	}
+}
+
+// TestInnerXMLAcrossBufferCleanup tests InnerXML for content larger than the cleanup threshold
+func TestInnerXMLAcrossBufferCleanup(t *testing.T) {
+	config := DefaultConfig()
+	config.BufferCleanupThreshold = 16
+	parser := NewStreamXmlParserWithConfig(config)
+
+	var inner strings.Builder
+	parser.Append("<log>")
+	for i := 0; i < 50; i++ {
+		chunk := fmt.Sprintf("<line n=%d>entry %d</line>\n", i, i)
+		inner.WriteString(chunk)
+		parser.Append(chunk)
+	}
+	parser.Append("</log>")
+
+	node, _ := parser.GetXmlNode()
+	if got := node.InnerXML(); got != inner.String() {
+		t.Errorf("expected %d bytes of inner XML, got %d: %q", inner.Len(), len(got), got)
+	}
+}
+
+// TestTypedAttributeAccessors tests parsing attribute values as numbers and booleans
+func TestTypedAttributeAccessors(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append(`<tool n="42" neg="-7" flag="true" off="0" ratio="0.25" exp="1e3" bad="4x" word="maybe"/>`)
+	node, _ := parser.GetXmlNode()
+
+	intTests := []struct {
+		key      string
+		expected int
+		ok       bool
+	}{
+		{"n", 42, true}, {"neg", -7, true}, {"ratio", 0, false}, {"bad", 0, false}, {"missing", 0, false},
+	}
+	for _, tt := range intTests {
+		if v, ok := node.AttrInt(tt.key); v != tt.expected || ok != tt.ok {
+			t.Errorf("AttrInt(%q): expected %d, %v, got %d, %v", tt.key, tt.expected, tt.ok, v, ok)
+		}
+	}
+
+	boolTests := []struct {
+		key      string
+		expected bool
+		ok       bool
+	}{
+		{"flag", true, true}, {"off", false, true}, {"word", false, false}, {"missing", false, false},
+	}
+	for _, tt := range boolTests {
+		if v, ok := node.AttrBool(tt.key); v != tt.expected || ok != tt.ok {
+			t.Errorf("AttrBool(%q): expected %v, %v, got %v, %v", tt.key, tt.expected, tt.ok, v, ok)
+		}
+	}
+
+	floatTests := []struct {
+		key      string
+		expected float64
+		ok       bool
+	}{
+		{"ratio", 0.25, true}, {"exp", 1000, true}, {"n", 42, true}, {"bad", 0, false}, {"missing", 0, false},
+	}
+	for _, tt := range floatTests {
+		if v, ok := node.AttrFloat(tt.key); v != tt.expected || ok != tt.ok {
+			t.Errorf("AttrFloat(%q): expected %v, %v, got %v, %v", tt.key, tt.expected, tt.ok, v, ok)
+		}
+	}
+}
+
+// TestPath tests slash paths over a three-level tree and for parsed top-level nodes
+func TestPath(t *testing.T) {
+	response := &XmlNode{Name: "response"}
+	tool := &XmlNode{Name: "tool", parent: response}
+	arg := &XmlNode{Name: "arg", parent: tool}
+
+	tests := []struct {
+		node     *XmlNode
+		expected string
+	}{
+		{response, "response"},
+		{tool, "response/tool"},
+		{arg, "response/tool/arg"},
+	}
+	for _, tt := range tests {
+		if got := tt.node.Path(); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+
+	parser := NewStreamXmlParser()
+	parser.Append("<response><tool><arg>x</arg></tool></response>")
+	node, _ := parser.GetXmlNode()
+	if got := node.Path(); got != "response" {
+		t.Errorf("expected top-level path 'response', got %q", got)
+	}
+
+	config := DefaultConfig()
+	config.ChildNodes = true
+	parser = NewStreamXmlParserWithConfig(config)
+	parser.Append("<response><tool><arg>x</arg></tool><tool/></response>")
+	node, _ = parser.GetXmlNode()
+	if got := node.Children[0].Children[0].Path(); got != "response/tool/arg" {
+		t.Errorf("expected parsed child path 'response/tool/arg', got %q", got)
+	}
+	if got := node.Children[1].Path(); got != "response/tool" {
+		t.Errorf("expected parsed child path 'response/tool', got %q", got)
+	}
+
+	// A copied child keeps its path without reaching into the parser's tree
+	parser = NewStreamXmlParserWithConfig(config)
+	parser.Append("<response><tool><arg>x")
+	current := parser.CurrentElement()
+	if current == nil || current.parent != nil {
+		t.Fatalf("expected a detached copy of the open child, got %+v", current)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		parser.Append("y</arg></tool></response>")
+	}()
+	if got := current.Path(); got != "response/tool/arg" {
+		t.Errorf("expected copied child path 'response/tool/arg', got %q", got)
+	}
+	<-done
+	if got := current.clone().Path(); got != "response/tool/arg" {
+		t.Errorf("expected the path to survive another copy, got %q", got)
+	}
+}
+
+// TestTextFromParsedTokens tests that text is taken from what was parsed, not
+// from re-reading the content
+func TestTextFromParsedTokens(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("<tool>a<![CDATA[<b>x</b>]]>c <i><![CDATA[1 < 2]]></i></tool>")
+	node, _ := parser.GetXmlNode()
+	if got := node.Text(); got != "a<b>x</b>c " {
+		t.Errorf("expected CDATA body in text, got %q", got)
+	}
+	if got := node.InnerText(); got != "a<b>x</b>c 1 < 2" {
+		t.Errorf("expected CDATA body in inner text, got %q", got)
+	}
+
+	config := DefaultConfig()
+	config.DecodeEntities = true
+	parser = NewStreamXmlParserWithConfig(config)
+	parser.Append("<tool>&lt;b&gt;bold&lt;/b&gt; <i>x &amp; y</i></tool>")
+	node, _ = parser.GetXmlNode()
+	if got := node.Text(); got != "<b>bold</b> " {
+		t.Errorf("expected decoded entities to stay text, got %q", got)
+	}
+	if got := node.InnerText(); got != "<b>bold</b> x & y" {
+		t.Errorf("expected decoded inner text, got %q", got)
+	}
+
+	parser = NewStreamXmlParser()
+	parser.SetContentUnwrap("json", "```json", "```")
+	parser.Append("<json>```json\n{}\n```</json>")
+	node, _ = parser.GetXmlNode()
+	if node.Text() != node.Content || node.InnerText() != node.Content {
+		t.Errorf("expected unwrapped text %q, got %q and %q", node.Content, node.Text(), node.InnerText())
+	}
+}
+
+// TestChildNodesTextAndMarshal tests that text and Marshal include children built with ChildNodes
+func TestChildNodesTextAndMarshal(t *testing.T) {
+	config := DefaultConfig()
+	config.ChildNodes = true
+	parser := NewStreamXmlParserWithConfig(config)
+	parser.Append(`<tool n="1">a <b>x<i/>y</b>c<d>z</d></tool><tool>open <e>p`)
+
+	nodes, _ := parser.GetXmlNodes()
+	if got := nodes[0].Text(); got != "a c" {
+		t.Errorf("expected text 'a c', got %q", got)
+	}
+	if got := nodes[0].InnerText(); got != "a xycz" {
+		t.Errorf("expected inner text 'a xycz', got %q", got)
+	}
+	if got := nodes[0].Marshal(); got != `<tool n="1">a <b>x<i/>y</b>c<d>z</d></tool>` {
+		t.Errorf("expected children in Marshal, got %q", got)
+	}
+	if got := nodes[1].Marshal(); got != "<tool>open <e>p" {
+		t.Errorf("expected partial children in Marshal, got %q", got)
+	}
+}
+
+// TestTextAndInnerText tests direct and recursive text of an element with mixed content
+func TestTextAndInnerText(t *testing.T) {
+	parser := NewStreamXmlParser()
+	input := "<answer>Use <tool name=\"x\">search <q>go</q> now</tool> then<br/> reply <!-- c --> done</answer>"
+	for i := 0; i < len(input); i += 5 {
+		parser.Append(input[i:min(i+5, len(input))])
+	}
+
+	node, _ := parser.GetXmlNode()
+	if node == nil {
+		t.Fatal("expected a node")
+	}
+	if got := node.Text(); got != "Use  then reply  done" {
+		t.Errorf("expected direct text %q, got %q", "Use  then reply  done", got)
+	}
+	if got := node.InnerText(); got != "Use search go now then reply  done" {
+		t.Errorf("expected inner text %q, got %q", "Use search go now then reply  done", got)
+	}
+
+	// Plain and partial content
+	parser = NewStreamXmlParser()
+	parser.Append("<a>plain</a><b>x <i>y")
+	nodes := parser.LiveNodes()
+	if nodes[0].Text() != "plain" || nodes[0].InnerText() != "plain" {
+		t.Errorf("expected plain text, got %q and %q", nodes[0].Text(), nodes[0].InnerText())
+	}
+	if nodes[1].Text() != "x " || nodes[1].InnerText() != "x y" {
+		t.Errorf("expected partial text, got %q and %q", nodes[1].Text(), nodes[1].InnerText())
+	}
+}
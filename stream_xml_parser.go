@@ -15,8 +15,16 @@
 package streamxml
 
 import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 type ASTNodeType int
@@ -24,6 +32,27 @@ type ASTNodeType int
 const (
 	ASTNodeText ASTNodeType = iota
 	ASTNodeXml
+	ASTNodeComment
+	ASTNodeWhitespace // top-level text that is only whitespace (see ParserConfig.WhitespaceNodes)
+)
+
+// isText reports whether nodes of this type hold top-level text
+func (t ASTNodeType) isText() bool {
+	return t == ASTNodeText || t == ASTNodeWhitespace
+}
+
+// ParserState describes where in the stream the parser currently is (see State)
+type ParserState int
+
+const (
+	// StateText is top-level text outside any element
+	StateText ParserState = iota
+	// StateInTag is inside an opening or self-closing tag that is not complete yet
+	StateInTag
+	// StateInContent is inside the content of an open element
+	StateInContent
+	// StateInClosingTag is inside a closing tag that is not complete yet
+	StateInClosingTag
 )
 
 type ASTNode struct {
@@ -37,9 +66,93 @@ type XmlNode struct {
 	Name       string
 	Attributes map[string]string
 	Content    string
-	Partial    bool
-	StartPos   int
-	EndPos     int
+
+	// RawAttributes is the tag text between the element name and the closing > or />, as written
+	RawAttributes string
+
+	// OrderedAttributes lists all attributes in document order, including repeated names
+	OrderedAttributes []Attribute
+
+	// AttributesTruncated reports that attributes past ParserConfig.MaxStoredAttributes were not stored
+	AttributesTruncated bool
+
+	Partial  bool
+	StartPos int
+	EndPos   int
+
+	// InnerStartPos is the position just after the opening tag; EndPos is the start of the closing tag
+	InnerStartPos int
+
+	// innerXML holds the original bytes between the opening and closing tags (see InnerXML)
+	innerXML string
+
+	// Ordinal is the position of the node among all XML nodes seen by the parser
+	Ordinal int
+
+	// PrecedingText is the top-level text directly before the element, back to the previous node
+	PrecedingText string
+
+	// SelfClosing reports whether the element was written as <name/>
+	SelfClosing bool
+
+	// Directive reports whether the element name starts with '@', as in
+	// <@set key="x"/>; see ParserConfig.StripDirectiveSigil
+	Directive bool
+
+	// Children holds the nested elements in document order when
+	// ParserConfig.ChildNodes is set; a child whose closing tag has not
+	// arrived yet is Partial
+	Children []*XmlNode
+
+	// childOffset is the position in the parent's Content where the child appeared
+	childOffset int
+
+	// Comments holds comments found in the content when ElementCommentMode is CommentNode
+	Comments []string
+
+	// ContentDropped reports that DropContent stopped collecting the content;
+	// Content then holds only what arrived before
+	ContentDropped bool
+
+	// AppendSpan holds the 0-based indexes of the first and last Append calls
+	// that contributed bytes to the node, when ParserConfig.TrackAppendSpans is set
+	AppendSpan [2]int
+
+	// base64Content marks nodes whose content is base64-encoded (see SetBase64Elements)
+	base64Content bool
+
+	// JSONValid reports whether the content is well-formed JSON for elements
+	// registered via SetJSONContentElements; nil until decidable
+	JSONValid *bool
+
+	// text and innerText hold the text recorded for Text and InnerText, built
+	// in textBuffers while the element is open
+	text        string
+	innerText   string
+	textBuffers *nodeText
+
+	// jsonChecker incrementally validates content (see SetJSONContentElements)
+	jsonChecker *jsonChecker
+	jsonFed     int
+
+	// preserveFormatting makes Marshal reuse RawAttributes (see ParserConfig.PreserveFormatting)
+	preserveFormatting bool
+
+	// contentAttribute names the attribute used as content for self-closing elements (see SetContentAttribute)
+	contentAttribute string
+
+	// parent is the enclosing element; nil for top-level nodes (see Path)
+	parent *XmlNode
+
+	// path is the Path of a copy made without its enclosing elements, which
+	// keeps it from reaching into the parser's tree
+	path string
+}
+
+// Attribute is a single name="value" pair of an element
+type Attribute struct {
+	Name  string
+	Value string
 }
 
 type StreamXmlParser struct {
@@ -47,11 +160,25 @@ type StreamXmlParser struct {
 	tokenizer      *StreamXmlTokenizer
 	astNodes       []ASTNode
 	xmlStack       []*XmlNode
+	parentContent  []string // content of the enclosing elements of an open child (see ParserConfig.ChildNodes)
 	textParts      []string
-	currentContent strings.Builder
+	currentContent contentBuffer
 	depth          int
 	config         ParserConfig
 
+	// Names of the open elements, outermost first, one per depth level
+	openNames []string
+
+	// Allowed elements requested via SetAllowedElements, and whether the
+	// tokenizer currently also allows elements that were open at that time
+	allowedElements []string
+	allowedWidened  bool
+
+	// Original bytes of the current top-level element's content, up to innerPos
+	// (a stream offset, so it survives buffer cleanup)
+	innerXML strings.Builder
+	innerPos int
+
 	// Tag reconstruction state
 	collectingTag bool
 	tagTokens     []*Token
@@ -60,6 +187,97 @@ type StreamXmlParser struct {
 	// Track current incomplete node being built
 	currentPartialNode *XmlNode
 	partialNodeIndex   int
+	partialTagPending  bool // currentPartialNode comes from a tag that is not complete yet
+
+	// Length of the content suffix shown for an incomplete tag inside an element
+	provisionalContent int
+
+	// Trailing text that may be the start of an entity split across chunks,
+	// and its position (see ParserConfig.DecodeEntities)
+	entityPending    string
+	entityPendingPos int
+
+	// Number of XML nodes created so far, used for XmlNode.Ordinal
+	nodeCount int
+
+	// Whether any XML node has been added to the AST (see HasXml)
+	hasXml bool
+
+	// Number of Append calls that added data, used for XmlNode.AppendSpan
+	appendCount int
+
+	// Top-level text seen before the first XML node and not cleared by
+	// ClearText, and whether any of it was not whitespace
+	preamble     strings.Builder
+	preambleText bool
+
+	// Pseudo-attributes of a leading <?xml ...?> declaration (see DeclaredEncoding)
+	declaration map[string]string
+
+	// Ordinal of the last node returned by NewCompletedNodes
+	polledOrdinal int
+
+	// Recoverable problems and end-of-stream state
+	warnings  []Warning
+	textified []TextifiedTag
+	finalized bool
+
+	// Element that ends the stream, and whether it was seen (see SetTerminatorElement)
+	terminatorElement string
+	terminated        bool
+
+	// Counters reported by Metrics
+	metrics parserMetrics
+
+	// While paused, appended data is buffered but not processed (see Pause)
+	paused bool
+
+	// Processing stopped at ParserConfig.MaxTokensPerAppend with input left (see Drain)
+	backlogged bool
+
+	// Trailing bytes that may start a zero-width character, held back until the
+	// next Append (see ParserConfig.StripZeroWidth)
+	zeroWidthPending string
+
+	// All appended data, kept when ParserConfig.RetainInput or
+	// ParserConfig.RetainRawInput is set (see Reparse and RawInput)
+	input strings.Builder
+
+	// Registered event sinks and callbacks
+	sinks       []Sink
+	events      *eventStream
+	tails       []*contentTail // readers returned by TailContent
+	onAppend    func(newTokens []Token)
+	onTextChunk func(chunk string)
+	onNodeOpen  func(node *XmlNode)
+
+	// Callbacks receiving copies of top-level nodes (see OnNodeComplete and OnNodeUpdate)
+	onNodeComplete func(node *XmlNode)
+	onNodeUpdate   func(node *XmlNode)
+
+	// Callback for changes of the top-level segment, and the current segment's
+	// element name ("" for text; see OnTopLevelTransition)
+	onTransition func(from, to string)
+	nameRewriter func(name string) string
+	tagValidator func(name string, attributes map[string]string) bool
+	segment      string
+
+	// Callbacks for the first bytes of element content (see OnContentPrefix)
+	contentPrefixes []contentPrefixWatch
+
+	// Per-element options
+	base64Elements      map[string]bool
+	contentAttributes   map[string]string
+	decodedAttributes   map[string]map[string]bool
+	canonicalAttributes map[string]map[string]bool
+	contentUnwraps      map[string][2]string
+	jsonContentElements map[string]bool
+	elementMaxDepth     map[string]int
+	requiredAttributes  map[string][]string
+
+	// Element whose content is text rather than node content (see SetTextElement)
+	textElement   string
+	inTextElement bool
 }
 
 func NewStreamXmlParser() *StreamXmlParser {
@@ -73,7 +291,38 @@ func NewStreamXmlParserWithConfig(config ParserConfig) *StreamXmlParser {
 		// Use default config if invalid
 		config = DefaultConfig()
 	}
+	return newStreamXmlParser(config)
+}
+
+// NewStreamXmlParserChecked creates a new parser with custom configuration,
+// returning ErrInvalidConfiguration instead of falling back to defaults
+func NewStreamXmlParserChecked(config ParserConfig) (*StreamXmlParser, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return newStreamXmlParser(config), nil
+}
+
+// Parse parses a complete document in one call: it appends input to a new
+// parser with the given configuration, finalizes it and returns the XML nodes
+// and text. On an error, such as ErrMaxDepthExceeded, the nodes and text
+// parsed up to that point are returned along with it; an invalid config
+// returns ErrInvalidConfiguration.
+func Parse(input string, config ParserConfig) ([]*XmlNode, string, error) {
+	parser, err := NewStreamXmlParserChecked(config)
+	if err != nil {
+		return nil, "", err
+	}
+	err = parser.Append(input)
+	if err == nil {
+		err = parser.Finalize()
+	}
+	text, _ := parser.GetText()
+	return parser.LiveNodes(), text, err
+}
 
+// newStreamXmlParser creates a parser from an already validated configuration
+func newStreamXmlParser(config ParserConfig) *StreamXmlParser {
 	parser := &StreamXmlParser{
 		tokenizer:          NewStreamXmlTokenizerWithConfig(config),
 		astNodes:           make([]ASTNode, 0),
@@ -85,25 +334,530 @@ func NewStreamXmlParserWithConfig(config ParserConfig) *StreamXmlParser {
 		tagTokens:          make([]*Token, 0),
 		currentPartialNode: nil,
 		partialNodeIndex:   -1,
+		polledOrdinal:      -1,
 	}
 
 	// Apply allowed elements from config to tokenizer
 	if config.AllowedElements != nil {
+		parser.allowedElements = slices.Clone(config.AllowedElements)
 		parser.tokenizer.SetAllowedElements(config.AllowedElements)
 	}
 
 	return parser
 }
 
+// Config returns a copy of the configuration in effect.
+// If the configuration given to NewStreamXmlParserWithConfig was invalid,
+// this reflects the defaults that were used instead.
+// This method is thread-safe.
+func (p *StreamXmlParser) Config() ParserConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	config := p.config
+	if config.AllowedElements != nil {
+		config.AllowedElements = append([]string{}, config.AllowedElements...)
+	}
+	return config
+}
+
 // SetAllowedElements configures which XML elements should be treated as XML tokens.
 // If nil, all elements are allowed (default behavior).
 // If empty slice, no elements are allowed (all tags treated as text).
 // If set with elements, only those elements will be tokenized as XML; others will be treated as text.
+//
+// The new set applies to tags that complete after the call, including a tag
+// that is partly received; such a tag becomes text if it is no longer allowed.
+// Elements that are open at the time of the call stay recognized until they
+// close, so their closing tags still match.
 // This method is thread-safe.
 func (p *StreamXmlParser) SetAllowedElements(elements []string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.tokenizer.SetAllowedElements(elements)
+
+	p.allowedElements = slices.Clone(elements)
+	p.applyAllowedElements()
+}
+
+// applyAllowedElements passes the requested allowed elements to the tokenizer,
+// widened by the names of the elements that are still open
+func (p *StreamXmlParser) applyAllowedElements() {
+	if p.allowedElements == nil || len(p.openNames) == 0 {
+		p.tokenizer.SetAllowedElements(p.allowedElements)
+		p.allowedWidened = false
+		return
+	}
+	p.tokenizer.SetAllowedElements(append(slices.Clone(p.allowedElements), p.openNames...))
+	p.allowedWidened = true
+}
+
+// SetNameRewriter registers a function that rewrites element names as they are
+// read, e.g. to strip a namespace prefix. Allowed elements, element options
+// and the matching of closing tags all see the rewritten name, which also
+// becomes XmlNode.Name; the names of partial tags are rewritten too. The
+// function runs while the parser lock is held, so it must not call back into
+// the parser. Passing nil removes the rewriter.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetNameRewriter(fn func(name string) string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nameRewriter = fn
+	p.tokenizer.nameRewriter = fn
+}
+
+// SetTagValidator registers a function that decides whether a complete
+// top-level opening or self-closing tag becomes an element. A rejected tag is
+// kept as text and reported by TextifiedTags with TextifyUnvalidated; its
+// closing tag is then handled like any stray closing tag (see StrayCloseMode).
+// The function must not modify attributes. It runs while the parser lock is
+// held, so it must not call back into the parser. Passing nil removes the
+// validator.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetTagValidator(fn func(name string, attributes map[string]string) bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tagValidator = fn
+}
+
+// Sink receives parse events as they happen
+type Sink interface {
+	// Node is called when a top-level XML node completes
+	Node(node *XmlNode)
+	// Text is called for each run of top-level text
+	Text(text string)
+	// Done is called once when the parser is finalized
+	Done()
+}
+
+// AddSink registers a sink that receives events during Append and Finalize.
+// Sinks are invoked in registration order while the parser lock is held, so
+// they must not call back into the parser.
+// This method is thread-safe.
+func (p *StreamXmlParser) AddSink(sink Sink) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sinks = append(p.sinks, sink)
+}
+
+// Events returns a channel of parse events: TextEvent for top-level text,
+// NodeStartEvent when a top-level opening tag completes and NodeCompleteEvent
+// when a node completes; their nodes are copies that are safe to read from
+// another goroutine. Only events after the first call are delivered, and
+// every call returns the same channel. Events are queued without bounds, so
+// Append never blocks on the consumer; the channel is closed after Finalize
+// once all queued events have been received.
+// This method is thread-safe.
+func (p *StreamXmlParser) Events() <-chan Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.events == nil {
+		p.events = newEventStream()
+		p.sinks = append(p.sinks, p.events)
+		if p.finalized {
+			p.events.Done()
+		}
+	}
+	return p.events.ch
+}
+
+// OnAppend registers a callback invoked at the end of every Append (unless
+// paused), Resume, Finalize and SetTextMode(true) with the tokens processed
+// during that call. Token positions refer to the tokenizer buffer. The callback
+// runs while the parser lock is held, so it must not call back into the parser.
+// Passing nil removes the callback.
+// This method is thread-safe.
+func (p *StreamXmlParser) OnAppend(fn func(newTokens []Token)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onAppend = fn
+}
+
+// OnTextChunk registers a callback invoked with each new piece of top-level
+// text as it is parsed, so the concatenation of all chunks equals GetText.
+// With ParserConfig.ContentTextChunks it also receives text content inside
+// elements. The callback runs while the parser lock is held, so it must not
+// call back into the parser. Passing nil removes the callback.
+// This method is thread-safe.
+func (p *StreamXmlParser) OnTextChunk(fn func(chunk string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onTextChunk = fn
+}
+
+// OnNodeOpen registers a callback invoked as soon as the opening tag of a
+// top-level element completes, with its name and attributes known and before
+// any of its content is parsed, e.g. to show that a tool call has started.
+// Incomplete opening tags do not fire it; self-closing elements fire it just
+// before they complete. The node is the live node, so read what is needed
+// during the call. The callback runs while the parser lock is held, so it must
+// not call back into the parser. Passing nil removes the callback.
+// This method is thread-safe.
+func (p *StreamXmlParser) OnNodeOpen(fn func(node *XmlNode)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onNodeOpen = fn
+}
+
+// OnNodeComplete registers a callback invoked exactly once for each top-level
+// node when it completes, at its closing tag or as a self-closing tag, with a
+// copy of the finished node. Nodes still partial at Finalize do not complete.
+// The callback runs while the parser lock is held, so it must not call back
+// into the parser; the copy may be kept and used after it returns. Passing nil
+// removes the callback.
+// This method is thread-safe.
+func (p *StreamXmlParser) OnNodeComplete(fn func(node *XmlNode)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onNodeComplete = fn
+}
+
+// OnNodeUpdate registers a callback invoked with a copy of the open top-level
+// node each time its content changes while it is partial. Like
+// OnNodeComplete, the callback runs while the parser lock is held and must not
+// call back into the parser. Passing nil removes the callback.
+// This method is thread-safe.
+func (p *StreamXmlParser) OnNodeUpdate(fn func(node *XmlNode)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onNodeUpdate = fn
+}
+
+// OnTopLevelTransition registers a callback invoked when the top level of the
+// stream switches between text and an element, or between elements with
+// different names, e.g. to route output. from and to are element names, or ""
+// for text; the stream starts in text, so a leading element reports from "".
+// Consecutive elements with the same name do not fire. The callback runs while
+// the parser lock is held, so it must not call back into the parser. Passing
+// nil removes the callback.
+// This method is thread-safe.
+func (p *StreamXmlParser) OnTopLevelTransition(fn func(from, to string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onTransition = fn
+}
+
+// transitionTo records that the top level continues with the given segment
+// ("" for text) and fires the OnTopLevelTransition callback if it changed
+func (p *StreamXmlParser) transitionTo(segment string) {
+	if segment == p.segment {
+		return
+	}
+	from := p.segment
+	p.segment = segment
+	if p.onTransition != nil {
+		p.onTransition(from, segment)
+	}
+}
+
+// contentPrefixWatch is a callback registered with OnContentPrefix
+type contentPrefixWatch struct {
+	name string
+	n    int
+	fn   func(prefix string)
+	node *XmlNode // last node the callback fired for
+}
+
+// OnContentPrefix registers a callback invoked once per top-level element
+// with the given name as soon as its content reaches n bytes, with the first
+// n bytes of content, e.g. to start work before the rest streams in. A
+// multibyte character cut by the n-th byte is included whole. Nested elements
+// and elements whose content stays shorter than n bytes do not trigger it; n
+// below 1 is treated as 1. The callback runs while the parser lock is held,
+// so it must not call back into the parser.
+// This method is thread-safe.
+func (p *StreamXmlParser) OnContentPrefix(name string, n int, fn func(prefix string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.contentPrefixes = append(p.contentPrefixes, contentPrefixWatch{
+		name: name,
+		n:    max(n, 1),
+		fn:   fn,
+	})
+}
+
+// SetBase64Elements configures which XML elements carry base64-encoded content.
+// Nodes with these names decode their content in XmlNode.ContentBytes.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetBase64Elements(elements []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.base64Elements = make(map[string]bool)
+	for _, elem := range elements {
+		p.base64Elements[elem] = true
+	}
+}
+
+// SetContentAttribute configures the attribute of a self-closing element that
+// XmlNode.EffectiveContent returns in place of the (empty) content.
+// An empty attr removes the mapping for the element.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetContentAttribute(element string, attr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if attr == "" {
+		delete(p.contentAttributes, element)
+		return
+	}
+	if p.contentAttributes == nil {
+		p.contentAttributes = make(map[string]string)
+	}
+	p.contentAttributes[element] = attr
+}
+
+// SetDecodedAttributes configures which attributes of an element have XML
+// entities (&amp;, &lt;, &#39;, ...) decoded in their values. Other attributes
+// are kept exactly as written. An empty attrs removes the setting for the element.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetDecodedAttributes(element string, attrs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(attrs) == 0 {
+		delete(p.decodedAttributes, element)
+		return
+	}
+	if p.decodedAttributes == nil {
+		p.decodedAttributes = make(map[string]map[string]bool)
+	}
+	decoded := make(map[string]bool)
+	for _, attr := range attrs {
+		decoded[attr] = true
+	}
+	p.decodedAttributes[element] = decoded
+}
+
+// SetCanonicalAttributes configures attributes of an element whose values are
+// lowercased at parse time, e.g. enum-like attributes such as type="SEARCH",
+// so they compare canonically. Other attributes are kept exactly as written.
+// An empty attrs removes the setting for the element.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetCanonicalAttributes(element string, attrs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(attrs) == 0 {
+		delete(p.canonicalAttributes, element)
+		return
+	}
+	if p.canonicalAttributes == nil {
+		p.canonicalAttributes = make(map[string]map[string]bool)
+	}
+	canonical := make(map[string]bool)
+	for _, attr := range attrs {
+		canonical[attr] = true
+	}
+	p.canonicalAttributes[element] = canonical
+}
+
+// DropContent stops collecting the content of the currently open top-level
+// element if it has the given name, e.g. once a consumer has read enough of a
+// large <file>. Content received so far is kept and further content bytes are
+// discarded, while the element is still tracked and completes at its closing
+// tag with ContentDropped set. It has no effect if no such element is open.
+// This method is thread-safe.
+func (p *StreamXmlParser) DropContent(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.xmlStack) == 0 || p.xmlStack[0].Name != name {
+		return
+	}
+	p.dropProvisionalContent()
+	p.xmlStack[0].ContentDropped = true
+}
+
+// SetTerminatorElement configures an element that ends the stream, such as
+// <done/>. When it completes at the top level it is kept as a node, the parser
+// finalizes as if Finalize were called, and any input after it is ignored;
+// later calls to Append return ErrStreamTerminated. An empty name removes the setting.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetTerminatorElement(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.terminatorElement = name
+}
+
+// SetContentUnwrap configures a wrapper, such as "```json\n" and "\n```", that
+// is removed from an element's content when the element completes. Surrounding
+// whitespace is ignored when matching, and the content is only changed if it
+// has both the prefix and the suffix. Empty prefix and suffix remove the setting.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetContentUnwrap(element string, prefix, suffix string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if prefix == "" && suffix == "" {
+		delete(p.contentUnwraps, element)
+		return
+	}
+	if p.contentUnwraps == nil {
+		p.contentUnwraps = make(map[string][2]string)
+	}
+	p.contentUnwraps[element] = [2]string{prefix, suffix}
+}
+
+// unwrapContent removes the wrapper configured with SetContentUnwrap from a completed node
+func (p *StreamXmlParser) unwrapContent(xmlNode *XmlNode) {
+	wrapper, ok := p.contentUnwraps[xmlNode.Name]
+	if !ok {
+		return
+	}
+	prefix, suffix := wrapper[0], wrapper[1]
+	content := strings.TrimSpace(xmlNode.Content)
+	if len(content) < len(prefix)+len(suffix) || !strings.HasPrefix(content, prefix) || !strings.HasSuffix(content, suffix) {
+		return
+	}
+	unwrapped := content[len(prefix) : len(content)-len(suffix)]
+	// Content without markup is all text, so the text is unwrapped too
+	if xmlNode.innerText == xmlNode.Content {
+		xmlNode.innerText = unwrapped
+	}
+	if xmlNode.text == xmlNode.Content {
+		xmlNode.text = unwrapped
+	}
+	xmlNode.Content = unwrapped
+}
+
+// SetTextElement configures an element whose content is treated as top-level
+// text instead of node content, e.g. a <response> element wrapping all prose.
+// Its own tags do not appear in the output, and elements nested in it become
+// nodes as if they were at the top level. An empty name disables the feature.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetTextElement(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.textElement = name
+}
+
+// SetElementMaxDepth sets the maximum nesting depth inside top-level elements
+// with the given name, counting the element itself like MaxDepth does. It
+// replaces MaxDepth for those elements, so it may be higher or lower.
+// A depth below 1 removes the setting for the element.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetElementMaxDepth(name string, depth int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if depth < 1 {
+		delete(p.elementMaxDepth, name)
+		return
+	}
+	if p.elementMaxDepth == nil {
+		p.elementMaxDepth = make(map[string]int)
+	}
+	p.elementMaxDepth[name] = depth
+}
+
+// SetRequiredAttributes declares attributes that the named element must have.
+// When such an element completes without one of them, Append returns an error
+// wrapping ErrMissingRequiredAttribute that names the element and attribute;
+// the node itself is still added. A nil or empty attrs removes the requirement.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetRequiredAttributes(element string, attrs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(attrs) == 0 {
+		delete(p.requiredAttributes, element)
+		return
+	}
+	if p.requiredAttributes == nil {
+		p.requiredAttributes = make(map[string][]string)
+	}
+	p.requiredAttributes[element] = slices.Clone(attrs)
+}
+
+// checkDepth returns an error if the current depth exceeds the limit for the
+// open top-level element. With ParserConfig.LenientLimits the error is
+// recorded as a warning instead and the element stays flattened into content.
+func (p *StreamXmlParser) checkDepth() error {
+	err := p.depthError()
+	if err != nil && p.config.LenientLimits {
+		p.addWarning(err, p.tagStartPos, p.openNames[len(p.openNames)-1])
+		return nil
+	}
+	return err
+}
+
+// depthError returns the error for the current depth, if it exceeds the limit
+func (p *StreamXmlParser) depthError() error {
+	if len(p.xmlStack) > 0 {
+		name := p.xmlStack[0].Name
+		if limit, ok := p.elementMaxDepth[name]; ok {
+			if p.depth > limit {
+				return fmt.Errorf("in <%s>: %w", name, ErrMaxDepthExceeded)
+			}
+			return nil
+		}
+	}
+	if p.depth > p.config.MaxDepth {
+		return ErrMaxDepthExceeded
+	}
+	return nil
+}
+
+// nextOrdinal returns the ordinal for a newly created XML node
+func (p *StreamXmlParser) nextOrdinal() int {
+	ordinal := p.nodeCount
+	p.nodeCount++
+	return ordinal
+}
+
+// SetJSONContentElements configures which XML elements should have their
+// content checked for JSON well-formedness as it streams in (see XmlNode.JSONValid).
+// This method is thread-safe.
+func (p *StreamXmlParser) SetJSONContentElements(names []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.jsonContentElements = make(map[string]bool)
+	for _, name := range names {
+		p.jsonContentElements[name] = true
+	}
+}
+
+// applyElementOptions applies per-element options to a node once its name is known
+func (p *StreamXmlParser) applyElementOptions(xmlNode *XmlNode) {
+	if strings.HasPrefix(xmlNode.Name, "@") {
+		xmlNode.Directive = true
+		if p.config.StripDirectiveSigil {
+			xmlNode.Name = xmlNode.Name[1:]
+		}
+	}
+	xmlNode.base64Content = p.base64Elements[xmlNode.Name]
+	xmlNode.contentAttribute = p.contentAttributes[xmlNode.Name]
+	xmlNode.preserveFormatting = p.config.PreserveFormatting
+	decoded := p.decodedAttributes[xmlNode.Name]
+	canonical := p.canonicalAttributes[xmlNode.Name]
+	if decoded != nil || canonical != nil || p.config.DecodeEntities {
+		for i, attr := range xmlNode.OrderedAttributes {
+			decode := p.config.DecodeEntities || decoded[attr.Name]
+			if !decode && !canonical[attr.Name] {
+				continue
+			}
+			value := attr.Value
+			if decode {
+				value = decodeEntities(value)
+			}
+			if canonical[attr.Name] {
+				value = strings.ToLower(value)
+			}
+			xmlNode.OrderedAttributes[i].Value = value
+			xmlNode.Attributes[attr.Name] = value
+		}
+	}
+	if p.jsonContentElements[xmlNode.Name] {
+		xmlNode.jsonChecker = &jsonChecker{}
+		xmlNode.jsonFed = 0
+		xmlNode.JSONValid = nil
+		p.checkJSONContent(xmlNode)
+	} else {
+		xmlNode.jsonChecker = nil
+	}
 }
 
 // Append adds new data to the parser and processes new tokens incrementally
@@ -112,57 +866,527 @@ func (p *StreamXmlParser) Append(data string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if err := p.tokenizer.Append(data); err != nil {
+	if p.terminated {
+		return ErrStreamTerminated
+	}
+	chunk, pending := data, ""
+	if p.config.StripZeroWidth {
+		chunk, pending = p.stripZeroWidth(data)
+	}
+	if err := p.tokenizer.Append(chunk); err != nil {
+		p.metrics.errors++
 		return err
 	}
-	return p.processNewTokens()
+	p.zeroWidthPending = pending
+	p.metrics.bytesAppended += len(data)
+	p.appendCount++
+	if p.config.RetainInput || p.config.RetainRawInput {
+		p.input.WriteString(data)
+	}
+	if p.paused {
+		return nil
+	}
+	return p.processNewTokensTracked()
+}
+
+// zeroWidthReplacer removes the characters dropped by ParserConfig.StripZeroWidth
+var zeroWidthReplacer = strings.NewReplacer("\u200b", "", "\u200c", "", "\u200d", "", "\ufeff", "")
+
+// stripZeroWidth removes zero-width characters from the held back bytes and
+// data. A trailing partial UTF-8 sequence that could start one is returned
+// separately, to be held back until the next chunk, so a character split
+// across Append calls is still recognized.
+func (p *StreamXmlParser) stripZeroWidth(data string) (chunk, pending string) {
+	data = p.zeroWidthPending + data
+	for k := 2; k >= 1; k-- {
+		if len(data) < k {
+			continue
+		}
+		tail := data[len(data)-k:]
+		if strings.HasPrefix("\u200b", tail) || strings.HasPrefix("\ufeff", tail) {
+			pending = tail
+			data = data[:len(data)-k]
+			break
+		}
+	}
+	return zeroWidthReplacer.Replace(data), pending
+}
+
+// Reparse creates a new parser with the given configuration and feeds it all
+// input appended so far, e.g. to parse again with different AllowedElements.
+// The full input is available if ParserConfig.RetainInput is set or if buffer
+// cleanup has not discarded any of it yet; otherwise ErrInputNotRetained is
+// returned. Options set with the Set* methods are not carried over.
+// This method is thread-safe.
+func (p *StreamXmlParser) Reparse(config ParserConfig) (*StreamXmlParser, error) {
+	p.mu.RLock()
+	input, ok := p.rawInput(p.config.RetainInput)
+	p.mu.RUnlock()
+	if !ok {
+		return nil, ErrInputNotRetained
+	}
+
+	parser, err := NewStreamXmlParserChecked(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := parser.Append(input); err != nil {
+		return nil, err
+	}
+	return parser, nil
 }
 
-// processNewTokens processes new tokens from the tokenizer incrementally
-func (p *StreamXmlParser) processNewTokens() error {
-	for {
+// Reset clears all parse state so the parser can be reused for a new,
+// independent stream, behaving like a freshly constructed parser with the same
+// configuration. The allowed elements, per-element options set with the Set*
+// methods, and registered callbacks and sinks are kept; sinks are not notified.
+// A channel returned by Events is closed, so call Events again for the new
+// stream. Metrics start again from zero.
+// This method is thread-safe.
+func (p *StreamXmlParser) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.events != nil {
+		p.events.Done()
+		p.sinks = slices.DeleteFunc(p.sinks, func(sink Sink) bool { return sink == Sink(p.events) })
+		p.events = nil
+	}
+
+	p.tokenizer = NewStreamXmlTokenizerWithConfig(p.config)
+	p.tokenizer.SetAllowedElements(p.allowedElements)
+	p.metrics = parserMetrics{}
+	p.tokenizer.nameRewriter = p.nameRewriter
+	p.allowedWidened = false
+
+	// Keep the backing arrays, but drop references into the old stream
+	clear(p.astNodes)
+	p.astNodes = p.astNodes[:0]
+	clear(p.xmlStack)
+	p.xmlStack = p.xmlStack[:0]
+	p.finishTails(nil)
+	clear(p.parentContent)
+	p.parentContent = p.parentContent[:0]
+	clear(p.textParts)
+	p.textParts = p.textParts[:0]
+	clear(p.tagTokens)
+	p.tagTokens = p.tagTokens[:0]
+	p.openNames = p.openNames[:0]
+	p.currentContent.Reset()
+	p.innerXML.Reset()
+	p.preamble.Reset()
+	p.preambleText = false
+	p.input.Reset()
+
+	p.depth = 0
+	p.innerPos = 0
+	p.collectingTag = false
+	p.tagStartPos = 0
+	p.currentPartialNode = nil
+	p.partialNodeIndex = -1
+	p.partialTagPending = false
+	p.provisionalContent = 0
+	p.entityPending = ""
+	p.nodeCount = 0
+	p.hasXml = false
+	p.appendCount = 0
+	p.declaration = nil
+	p.polledOrdinal = -1
+	p.warnings = nil
+	p.textified = nil
+	p.finalized = false
+	p.terminated = false
+	p.paused = false
+	p.backlogged = false
+	p.zeroWidthPending = ""
+	p.segment = ""
+	p.inTextElement = false
+	for i := range p.contentPrefixes {
+		p.contentPrefixes[i].node = nil
+	}
+}
+
+// RawInput returns the exact data appended so far, the concatenation of all
+// Append calls, regardless of buffer cleanup. This requires
+// ParserConfig.RetainRawInput; without it the input is only available until
+// buffer cleanup discards part of it (or, with StripZeroWidth, not at all),
+// and RawInput returns "" after that.
+// This method is thread-safe.
+func (p *StreamXmlParser) RawInput() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	input, _ := p.rawInput(p.config.RetainRawInput)
+	return input
+}
+
+// rawInput returns all appended data, and false if it is no longer available.
+// retained reports whether the config flag the caller depends on keeps the input.
+func (p *StreamXmlParser) rawInput(retained bool) (string, bool) {
+	switch {
+	case retained:
+		return p.input.String(), true
+	case p.tokenizer.discarded == 0 && !p.config.StripZeroWidth:
+		return p.tokenizer.GetBuffer(), true
+	default:
+		return "", false
+	}
+}
+
+// Pause stops the parser from producing new nodes, text and events. Appended
+// data is still buffered and is processed when Resume is called.
+// This method is thread-safe.
+func (p *StreamXmlParser) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+}
+
+// Resume processes the data buffered while paused and returns to processing
+// data as it is appended. Errors are those Append would have returned.
+// This method is thread-safe.
+func (p *StreamXmlParser) Resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused {
+		return nil
+	}
+	p.paused = false
+	return p.processNewTokensTracked()
+}
+
+// Drain continues processing input left over when a call stopped at
+// ParserConfig.MaxTokensPerAppend, again up to that many tokens, and reports
+// whether input is still left. Appending more data also continues processing.
+// Errors are those Append would have returned.
+// This method is thread-safe.
+func (p *StreamXmlParser) Drain() (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused || !p.backlogged {
+		return false, nil
+	}
+	err := p.processNewTokensTracked()
+	return p.backlogged, err
+}
+
+// SetTextMode turns tag recognition off or on. While on, all appended data is
+// text (or content of the open element) regardless of '<', e.g. inside a
+// fenced code block. A tag that is incomplete when text mode is turned on is
+// kept as text.
+// This method is thread-safe.
+func (p *StreamXmlParser) SetTextMode(on bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tokenizer.SetTextMode(on)
+	if on && !p.paused {
+		// Only text tokens can be produced here, so no error is possible
+		_ = p.processNewTokens(0)
+	}
+}
+
+// PushContext tells the parser that the stream starts inside an already open
+// element, e.g. when joining a stream late. The following data becomes the
+// content of a partial node named name until its closing tag arrives.
+// It returns ErrContextNotAtTopLevel if the parser is inside an element or tag.
+func (p *StreamXmlParser) PushContext(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.depth > 0 || p.collectingTag || p.tokenizer.inTag {
+		return ErrContextNotAtTopLevel
+	}
+
+	position := len(p.tokenizer.GetBuffer())
+	xmlNode := &XmlNode{
+		Ordinal:       p.nextOrdinal(),
+		PrecedingText: p.precedingText(),
+		Name:          name,
+		Attributes:    make(map[string]string),
+		Partial:       true,
+		StartPos:      position,
+	}
+	if p.config.TrackAppendSpans {
+		// The node's content starts with the next Append
+		xmlNode.AppendSpan = [2]int{p.appendCount, p.appendCount}
+	}
+	p.applyElementOptions(xmlNode)
+	p.appendXmlNode(xmlNode, position)
+	p.currentPartialNode = xmlNode
+	p.partialNodeIndex = len(p.astNodes) - 1
+
+	p.xmlStack = append(p.xmlStack, xmlNode)
+	p.currentContent.Reset()
+	p.startInnerXML(xmlNode, position)
+	p.startNode(xmlNode)
+	p.depth++
+	p.openNames = append(p.openNames, name)
+	p.syncCodeFences()
+	return nil
+}
+
+// Finalize signals that no more data will be appended and processes any
+// remaining input. Problems that only become certain at end of stream, such as
+// an attribute quote that never closes, are recorded as warnings. A trailing
+// incomplete tag stays a partial node only if it names a valid (and allowed)
+// element, as in "<tool"; otherwise, as in "a < b", it becomes text.
+// Finalize also ends a pause. Calling it more than once has no further effect.
+// This method is thread-safe.
+func (p *StreamXmlParser) Finalize() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.finalized {
+		return nil
+	}
+	p.finalized = true
+	p.paused = false
+	var err error
+	if p.zeroWidthPending != "" {
+		err = p.tokenizer.Append(p.zeroWidthPending)
+		p.zeroWidthPending = ""
+	}
+	p.tokenizer.Close()
+
+	if err == nil {
+		err = p.processNewTokens(0)
+	}
+	// The stream ends even on error, so sinks and event consumers are not left waiting
+	p.finishStream(err)
+	return err
+}
+
+// finishStream runs the end-of-stream handling of Finalize once all input is
+// processed. If processing failed with err, the remaining input is not resolved
+// but sinks are still notified.
+func (p *StreamXmlParser) finishStream(err error) {
+	if err == nil {
+		p.resolveTrailingInput()
+	}
+	p.finishTails(err)
+	for _, sink := range p.sinks {
+		sink.Done()
+	}
+}
+
+// resolveTrailingInput handles text and tags left over at the end of the stream
+func (p *StreamXmlParser) resolveTrailingInput() {
+	if p.entityPending != "" {
+		p.flushEntityPending()
+	}
+	if p.partialTagPending && p.tokenizer.inTag {
+		// A trailing tag that does not name an element is prose such as "a < b"
+		start := p.tokenizer.tagStartPos
+		value := p.tokenizer.GetBuffer()[start:]
+		if !p.namesElement(value) {
+			p.discardPartialNode()
+			p.addTextified(TextifyMalformed, start, value)
+			p.processText(value, start)
+		} else if p.tokenizer.inUnterminatedQuote() {
+			p.addWarning(ErrUnterminatedAttributeValue, start, value)
+		}
+	} else if p.tokenizer.inUnterminatedQuote() {
+		buffer := p.tokenizer.GetBuffer()
+		p.addWarning(ErrUnterminatedAttributeValue, p.tokenizer.tagStartPos, buffer[p.tokenizer.tagStartPos:])
+	}
+}
+
+// endIfTerminated finalizes the stream once the terminator element was seen
+func (p *StreamXmlParser) endIfTerminated() {
+	if p.terminated && !p.finalized {
+		p.finalized = true
+		p.paused = false
+		p.tokenizer.Close()
+		p.finishStream(nil)
+	}
+}
+
+// Warnings returns the recoverable problems recorded so far
+// This method is thread-safe.
+func (p *StreamXmlParser) Warnings() []Warning {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result := make([]Warning, len(p.warnings))
+	copy(result, p.warnings)
+	return result
+}
+
+// TextifiedTags returns the tags kept as text so far, with the reason for each,
+// to help find out why an expected element did not appear. Tags inside text
+// elements or code fences are text by design and not reported.
+// This method is thread-safe.
+func (p *StreamXmlParser) TextifiedTags() []TextifiedTag {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return slices.Clone(p.textified)
+}
+
+// addTextified records a tag kept as text
+func (p *StreamXmlParser) addTextified(reason TextifyReason, position int, tag string) {
+	name := strings.TrimPrefix(strings.TrimPrefix(tag, "<"), "/")
+	if end := strings.IndexFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '/' || r == '>' || r == '<'
+	}); end >= 0 {
+		name = name[:end]
+	}
+	p.textified = append(p.textified, TextifiedTag{
+		Name:     name,
+		Reason:   reason,
+		Position: position,
+	})
+}
+
+// addWarning records a recoverable problem
+func (p *StreamXmlParser) addWarning(err error, position int, detail string) {
+	p.metrics.recoveries++
+	p.warnings = append(p.warnings, Warning{
+		Err:      err,
+		Position: position,
+		Detail:   detail,
+	})
+}
+
+// processNewTokensTracked runs processNewTokens limited to MaxTokensPerAppend
+// and, with TrackAppendSpans, attributes the nodes it touched to the latest Append call
+func (p *StreamXmlParser) processNewTokensTracked() error {
+	limit := p.config.MaxTokensPerAppend
+	if !p.config.TrackAppendSpans {
+		return p.processNewTokens(limit)
+	}
+
+	active := p.currentPartialNode
+	if p.depth > 0 && len(p.xmlStack) > 0 {
+		active = p.xmlStack[0]
+	}
+	created := p.nodeCount
+
+	err := p.processNewTokens(limit)
+
+	index := p.appendCount - 1
+	if active != nil {
+		active.AppendSpan[1] = index
+	}
+	for i := len(p.astNodes) - 1; i >= 0; i-- {
+		node := p.astNodes[i]
+		if node.Type != ASTNodeXml {
+			continue
+		}
+		if node.XmlNode.Ordinal < created {
+			break
+		}
+		node.XmlNode.AppendSpan = [2]int{index, index}
+	}
+	return err
+}
+
+// processNewTokens processes new tokens from the tokenizer incrementally.
+// With a limit above 0 it stops after that many tokens and sets backlogged;
+// the remaining input stays in the tokenizer for a later call. It never stops
+// inside a tag, as buffer cleanup would shift the collected tag tokens.
+func (p *StreamXmlParser) processNewTokens(limit int) error {
+	var batch []Token
+	if p.onAppend != nil {
+		batch = make([]Token, 0)
+		defer func() { p.onAppend(batch) }()
+	}
+
+	p.backlogged = false
+	// Input after the terminator element is never processed
+	for count := 0; !p.terminated; count++ {
+		if limit > 0 && count >= limit && !p.collectingTag {
+			p.backlogged = true
+			break
+		}
 		token := p.tokenizer.NextToken()
 		if token == nil {
 			// No more tokens available
 			break
 		}
+		if p.onAppend != nil {
+			batch = append(batch, *token)
+		}
 
 		if err := p.processToken(token); err != nil {
+			p.metrics.errors++
+			p.endIfTerminated()
 			return err
 		}
+		p.syncCodeFences()
 	}
+	p.endIfTerminated()
 	return nil
 }
 
+// syncCodeFences limits code fence detection to the top level
+func (p *StreamXmlParser) syncCodeFences() {
+	p.tokenizer.codeFences = p.config.RespectCodeFences && p.depth == 0
+}
+
 // getValue extracts the value from buffer using token positions
 func (p *StreamXmlParser) getValue(token *Token) string {
 	buffer := p.tokenizer.GetBuffer()
-	if token.Start >= 0 && token.End <= len(buffer) {
+	if token.Start >= 0 && token.Start <= token.End && token.End <= len(buffer) {
 		return buffer[token.Start:token.End]
 	}
 	return ""
 }
 
+// isQuotedValue reports whether an attribute value token was written inside quotes
+func (p *StreamXmlParser) isQuotedValue(token *Token) bool {
+	buffer := p.tokenizer.GetBuffer()
+	if token.Start < 1 || token.Start > len(buffer) {
+		return false
+	}
+	ch := buffer[token.Start-1]
+	return ch == '"' || ch == '\''
+}
+
 // processToken processes a single token and updates the AST incrementally
 func (p *StreamXmlParser) processToken(token *Token) error {
+	if p.partialTagPending && (token.Type == TokenText || token.Type == TokenComment || token.Type == TokenCData) {
+		// The incomplete tag turned out to be text, a comment or CDATA; otherwise the
+		// tag tokens complete the same partial node
+		p.discardPartialNode()
+	}
+	if p.provisionalContent > 0 && token.Type != TokenIncomplete {
+		p.dropProvisionalContent()
+	}
+	if p.entityPending != "" && (token.Type != TokenText || token.textified != 0) {
+		// An entity cannot continue past markup
+		p.flushEntityPending()
+	}
+
+	if p.depth > 0 && (token.Type == TokenText || token.Type == TokenComment || token.Type == TokenCData) {
+		p.captureInnerXML(token.End)
+	}
+
 	switch token.Type {
 	case TokenText:
 		value := p.getValue(token)
-		if p.depth > 0 {
-			// We're inside an XML tag, accumulate as content
-			p.currentContent.WriteString(value)
-			// Update content in current open node
-			if len(p.xmlStack) > 0 {
-				p.xmlStack[len(p.xmlStack)-1].Content = p.currentContent.String()
+		position := token.Start
+		if token.textified != 0 {
+			p.addTextified(token.textified, token.Start, value)
+		} else if p.config.DecodeEntities {
+			value, position = p.decodeText(value, position)
+			if value == "" {
+				break
 			}
-		} else {
-			// We're outside XML tags, add as text node
-			p.astNodes = append(p.astNodes, ASTNode{
-				Type:     ASTNodeText,
-				Text:     value,
-				Position: token.Start,
-			})
-			p.textParts = append(p.textParts, value)
+		}
+		p.processText(value, position)
+
+	case TokenComment:
+		p.processComment(p.getValue(token), token.Start)
+
+	case TokenCData:
+		// The section body is verbatim text: no tags and no entity decoding
+		value := strings.TrimSuffix(strings.TrimPrefix(p.getValue(token), cdataStart), cdataEnd)
+		if value != "" {
+			p.processText(value, token.Start+len(cdataStart))
 		}
 
 	case TokenOpenBracket:
@@ -181,11 +1405,12 @@ func (p *StreamXmlParser) processToken(token *Token) error {
 		// Tag is complete
 		if p.collectingTag {
 			p.tagTokens = append(p.tagTokens, token)
-			if err := p.processCompleteTag(); err != nil {
-				return err
-			}
+			err := p.processCompleteTag()
 			p.collectingTag = false
 			p.tagTokens = nil
+			if err != nil {
+				return err
+			}
 		}
 
 	case TokenIncomplete:
@@ -193,9 +1418,10 @@ func (p *StreamXmlParser) processToken(token *Token) error {
 		if !token.Complete {
 			if p.depth == 0 {
 				value := p.getValue(token)
-				tagName := extractPartialTagName(value)
+				tagName := p.tokenizer.rewriteName(extractPartialTagName(value))
 
 				// Check if we already have a partial node being built
+				p.partialTagPending = true
 				if p.currentPartialNode != nil && p.partialNodeIndex >= 0 {
 					// Update existing partial node
 					if tagName != "" && tagName != p.currentPartialNode.Name {
@@ -204,55 +1430,441 @@ func (p *StreamXmlParser) processToken(token *Token) error {
 				} else {
 					// Create new partial node - even if no tag name yet
 					xmlNode := &XmlNode{
-						Name:       tagName,
-						Partial:    true,
-						Content:    "",
-						Attributes: make(map[string]string),
-						StartPos:   token.Start,
+						Ordinal:       p.nextOrdinal(),
+						PrecedingText: p.precedingText(),
+						Name:          tagName,
+						Partial:       true,
+						Content:       "",
+						Attributes:    make(map[string]string),
+						StartPos:      token.Start,
 					}
 
 					// Add to AST as partial
-					p.astNodes = append(p.astNodes, ASTNode{
-						Type:     ASTNodeXml,
-						XmlNode:  xmlNode,
-						Position: token.Start,
-					})
+					p.appendXmlNode(xmlNode, token.Start)
+
+					// Track this as current partial node
+					p.currentPartialNode = xmlNode
+					p.partialNodeIndex = len(p.astNodes) - 1
+				}
+			} else {
+				// Inside an element, show the incomplete tag provisionally until it
+				// resolves into a tag or text; closing tag fragments are never shown
+				p.dropProvisionalContent()
+				value := p.getValue(token)
+				if !isClosingTagFragment(value) && !p.contentDropped() {
+					p.currentContent.WriteString(value)
+					p.provisionalContent = len(value)
+					p.syncContent()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// decodeText decodes entities in a text token's value, together with text held
+// back from the previous token. A trailing '&' that may still become an entity
+// is held back in turn. It returns the text to process and its position.
+func (p *StreamXmlParser) decodeText(value string, position int) (string, int) {
+	if p.entityPending != "" {
+		value = p.entityPending + value
+		position = p.entityPendingPos
+		p.entityPending = ""
+	}
+	if i := strings.LastIndexByte(value, '&'); i >= 0 && maybeEntityPrefix(value[i+1:]) {
+		p.entityPending = value[i:]
+		p.entityPendingPos = position + i
+		value = value[:i]
+	}
+	return decodeEntities(value), position
+}
+
+// maybeEntityPrefix reports whether s, the text after a '&', may still grow
+// into an entity reference such as "amp;" or "#x2014;"
+func maybeEntityPrefix(s string) bool {
+	if len(s) > maxEntityNameLen {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '#') {
+			return false
+		}
+	}
+	return true
+}
+
+// flushEntityPending processes held back text verbatim once it cannot become an entity
+func (p *StreamXmlParser) flushEntityPending() {
+	value := p.entityPending
+	p.entityPending = ""
+	p.processText(value, p.entityPendingPos)
+}
+
+// discardPartialNode removes the partial node created for an incomplete tag
+// that did not become an element
+func (p *StreamXmlParser) discardPartialNode() {
+	xmlNode := p.currentPartialNode
+	p.partialTagPending = false
+	p.currentPartialNode = nil
+	p.partialNodeIndex = -1
+	if xmlNode == nil {
+		return
+	}
+
+	p.filterAST(func(node ASTNode) bool {
+		return node.XmlNode != xmlNode
+	})
+	if xmlNode.Ordinal == p.nodeCount-1 {
+		p.nodeCount--
+	}
+	if p.nodeCount == 0 {
+		// The tag was text after all, e.g. "a < b", so no XML was produced
+		p.hasXml = false
+	}
+}
+
+// dropProvisionalContent removes content shown for an incomplete tag
+func (p *StreamXmlParser) dropProvisionalContent() {
+	if p.provisionalContent == 0 {
+		return
+	}
+	p.currentContent.truncate(p.currentContent.Len() - p.provisionalContent)
+	p.provisionalContent = 0
+	p.syncContent()
+}
+
+// contentBuffer accumulates element content like a strings.Builder, whose
+// String does not copy, and can also drop a suffix without copying. Dropped
+// bytes stay in the builder, since strings returned earlier share them, and
+// are reused when the next write starts with them; typically the incomplete
+// tag they showed is written again once it completes or grows.
+type contentBuffer struct {
+	b    strings.Builder
+	dead int // trailing bytes of b dropped by truncate
+}
+
+func (c *contentBuffer) String() string {
+	s := c.b.String()
+	return s[:len(s)-c.dead]
+}
+
+func (c *contentBuffer) Len() int {
+	return c.b.Len() - c.dead
+}
+
+func (c *contentBuffer) Cap() int {
+	return c.b.Cap()
+}
+
+func (c *contentBuffer) Reset() {
+	c.b.Reset()
+	c.dead = 0
+}
+
+// truncate drops all but the first n bytes
+func (c *contentBuffer) truncate(n int) {
+	c.dead = c.b.Len() - n
+}
+
+func (c *contentBuffer) WriteString(s string) {
+	if c.dead > 0 {
+		all := c.b.String()
+		dropped := all[len(all)-c.dead:]
+		switch {
+		case strings.HasPrefix(dropped, s):
+			c.dead -= len(s)
+			return
+		case strings.HasPrefix(s, dropped):
+			s = s[len(dropped):]
+		default:
+			// Only here is the content copied
+			kept := all[:len(all)-c.dead]
+			c.b = strings.Builder{}
+			c.b.Grow(len(kept) + len(s))
+			c.b.WriteString(kept)
+		}
+		c.dead = 0
+	}
+	c.b.WriteString(s)
+}
+
+// processText adds text to the current element content or as a top-level text node
+func (p *StreamXmlParser) processText(value string, position int) {
+	if p.onTextChunk != nil && (p.depth == 0 || p.config.ContentTextChunks) {
+		p.onTextChunk(value)
+	}
+
+	if p.depth > 0 {
+		// We're inside an XML tag, accumulate as content
+		p.writeContent(value)
+		p.writeText(value)
+	} else {
+		// We're outside XML tags, add as text node
+		p.transitionTo("")
+		p.astNodes = append(p.astNodes, ASTNode{
+			Type:     p.textNodeType(value),
+			Text:     value,
+			Position: position,
+		})
+		p.textParts = append(p.textParts, value)
+		if p.nodeCount == 0 {
+			p.preamble.WriteString(value)
+			p.preambleText = p.preambleText || strings.TrimSpace(value) != ""
+		}
+		for _, sink := range p.sinks {
+			sink.Text(value)
+		}
+	}
+}
+
+// textNodeType classifies top-level text. With WhitespaceNodes, a run of text
+// between other nodes that is entirely whitespace becomes ASTNodeWhitespace;
+// since a run can arrive in pieces, earlier whitespace nodes of the run are
+// turned back into text when meaningful text follows.
+func (p *StreamXmlParser) textNodeType(value string) ASTNodeType {
+	if !p.config.WhitespaceNodes {
+		return ASTNodeText
+	}
+
+	last := len(p.astNodes) - 1
+	if strings.TrimSpace(value) == "" {
+		if last >= 0 && p.astNodes[last].Type == ASTNodeText {
+			return ASTNodeText
+		}
+		return ASTNodeWhitespace
+	}
+	for ; last >= 0 && p.astNodes[last].Type == ASTNodeWhitespace; last-- {
+		p.astNodes[last].Type = ASTNodeText
+	}
+	return ASTNodeText
+}
+
+// precedingText returns the text of the text nodes at the end of the AST,
+// back to the previous non-text node
+func (p *StreamXmlParser) precedingText() string {
+	start := len(p.astNodes)
+	for start > 0 && p.astNodes[start-1].Type.isText() {
+		start--
+	}
+
+	var text strings.Builder
+	for _, node := range p.astNodes[start:] {
+		text.WriteString(node.Text)
+	}
+	return text.String()
+}
+
+// appendXmlNode adds a top-level XML node to the AST
+func (p *StreamXmlParser) appendXmlNode(xmlNode *XmlNode, position int) {
+	p.astNodes = append(p.astNodes, ASTNode{
+		Type:     ASTNodeXml,
+		XmlNode:  xmlNode,
+		Position: position,
+	})
+	p.hasXml = true
+}
+
+// writeContent adds value to the content of the open element unless
+// DropContent stopped collecting it
+func (p *StreamXmlParser) writeContent(value string) {
+	if p.contentDropped() {
+		return
+	}
+	p.currentContent.WriteString(value)
+	p.syncContent()
+}
+
+// nodeText accumulates the text of an open element (see XmlNode.Text)
+type nodeText struct {
+	direct strings.Builder
+	inner  strings.Builder
+}
+
+// writeText records text for Text and InnerText: every open element gets it
+// as inner text, and the element it is directly inside also as its own text
+func (p *StreamXmlParser) writeText(value string) {
+	if p.contentDropped() {
+		return
+	}
+	for i, xmlNode := range p.xmlStack {
+		if xmlNode.textBuffers == nil {
+			xmlNode.textBuffers = &nodeText{}
+		}
+		buffers := xmlNode.textBuffers
+		buffers.inner.WriteString(value)
+		xmlNode.innerText = buffers.inner.String()
+		// Without ChildNodes only the top-level element is on the stack
+		if p.depth == i+1 {
+			buffers.direct.WriteString(value)
+			xmlNode.text = buffers.direct.String()
+		}
+	}
+}
+
+// contentDropped reports whether DropContent applies to the open element
+func (p *StreamXmlParser) contentDropped() bool {
+	return len(p.xmlStack) > 0 && p.xmlStack[0].ContentDropped
+}
+
+// syncContent copies the accumulated content into the current open node
+func (p *StreamXmlParser) syncContent() {
+	if len(p.xmlStack) == 0 {
+		return
+	}
+	xmlNode := p.xmlStack[len(p.xmlStack)-1]
+	xmlNode.Content = p.currentContent.String()
+	p.checkJSONContent(xmlNode)
+	if len(p.xmlStack) == 1 {
+		p.checkContentPrefixes(xmlNode)
+		p.feedTails(xmlNode, false)
+	}
+	if p.onNodeUpdate != nil {
+		p.onNodeUpdate(p.xmlStack[0].clone())
+	}
+}
+
+// checkContentPrefixes fires OnContentPrefix callbacks whose length the open
+// node's content has reached, not counting provisional content
+func (p *StreamXmlParser) checkContentPrefixes(xmlNode *XmlNode) {
+	stable := xmlNode.Content[:len(xmlNode.Content)-p.provisionalContent]
+	for i := range p.contentPrefixes {
+		watch := &p.contentPrefixes[i]
+		if watch.node == xmlNode || watch.name != xmlNode.Name {
+			continue
+		}
+		prefix, ok := bytePrefix(stable, watch.n)
+		if !ok {
+			continue
+		}
+		watch.node = xmlNode
+		watch.fn(prefix)
+	}
+}
+
+// bytePrefix returns the first n bytes of s, extended to the end of a rune
+// cut by the n-th byte, and false if s is shorter than n bytes
+func bytePrefix(s string, n int) (string, bool) {
+	if len(s) < n {
+		return "", false
+	}
+	for n < len(s) && !utf8.RuneStart(s[n]) {
+		n++
+	}
+	return s[:n], true
+}
+
+// startInnerXML begins recording the original content of a top-level element whose
+// opening tag ends at end
+func (p *StreamXmlParser) startInnerXML(xmlNode *XmlNode, end int) {
+	xmlNode.InnerStartPos = end
+	xmlNode.innerXML = ""
+	p.innerXML.Reset()
+	p.innerPos = p.tokenizer.discarded + end
+}
+
+// captureInnerXML records the original bytes of the current top-level element up to end
+func (p *StreamXmlParser) captureInnerXML(end int) {
+	if len(p.xmlStack) == 0 {
+		return
+	}
+	start := max(p.innerPos-p.tokenizer.discarded, 0)
+	if end > start && !p.contentDropped() {
+		p.innerXML.WriteString(p.tokenizer.GetBuffer()[start:end])
+	}
+	p.innerPos = p.tokenizer.discarded + end
+	p.xmlStack[0].innerXML = p.innerXML.String()
+}
+
+// checkJSONContent feeds new content of a JSON content element to its checker
+// and updates JSONValid once the outcome is known
+func (p *StreamXmlParser) checkJSONContent(xmlNode *XmlNode) {
+	if xmlNode.jsonChecker == nil {
+		return
+	}
+	if len(xmlNode.Content) < xmlNode.jsonFed {
+		// Content was rewritten, start over
+		xmlNode.jsonChecker = &jsonChecker{}
+		xmlNode.jsonFed = 0
+	}
+	xmlNode.jsonChecker.feed(xmlNode.Content[xmlNode.jsonFed:])
+	xmlNode.jsonFed = len(xmlNode.Content)
+
+	if xmlNode.jsonChecker.failed {
+		valid := false
+		xmlNode.JSONValid = &valid
+	} else if !xmlNode.Partial {
+		valid := xmlNode.jsonChecker.complete()
+		xmlNode.JSONValid = &valid
+	}
+}
+
+// startNode reports a top-level node whose opening tag just completed
+func (p *StreamXmlParser) startNode(xmlNode *XmlNode) {
+	p.metrics.nodesStarted++
+	p.transitionTo(xmlNode.Name)
+	p.bindTails(xmlNode)
+	if p.onNodeOpen != nil {
+		p.onNodeOpen(xmlNode)
+	}
+	if p.events != nil {
+		p.events.push(NodeStartEvent{Node: xmlNode.clone()})
+	}
+}
 
-					// Track this as current partial node
-					p.currentPartialNode = xmlNode
-					p.partialNodeIndex = len(p.astNodes) - 1
-				}
-			} else {
-				// Inside a tag - check if this is a closing tag fragment
-				value := p.getValue(token)
+// completeNode runs completion handling for a top-level node that just became
+// complete. It returns ErrMissingRequiredAttribute after the node is delivered
+// if an attribute registered with SetRequiredAttributes is missing.
+func (p *StreamXmlParser) completeNode(xmlNode *XmlNode) error {
+	p.metrics.nodesCompleted++
+	xmlNode.textBuffers = nil
+	p.feedTails(xmlNode, true)
+	p.unwrapContent(xmlNode)
+	if p.terminatorElement != "" && xmlNode.Name == p.terminatorElement {
+		p.terminated = true
+	}
+	for _, sink := range p.sinks {
+		sink.Node(xmlNode)
+	}
+	if p.onNodeComplete != nil {
+		p.onNodeComplete(xmlNode.clone())
+	}
 
-				if isClosingTagFragment(value) {
-					// This is a closing tag start (</...)
-					// Need to remove any trailing '<' that was previously added to content
-					currentContentStr := p.currentContent.String()
-					if strings.HasSuffix(currentContentStr, "<") {
-						// Remove the trailing '<'
-						p.currentContent.Reset()
-						p.currentContent.WriteString(strings.TrimSuffix(currentContentStr, "<"))
-						// Update content in current open node
-						if len(p.xmlStack) > 0 {
-							p.xmlStack[len(p.xmlStack)-1].Content = p.currentContent.String()
-						}
-					}
-				} else {
-					// Not a closing tag, add to content
-					p.currentContent.WriteString(value)
-					// Update content in current open node
-					if len(p.xmlStack) > 0 {
-						p.xmlStack[len(p.xmlStack)-1].Content = p.currentContent.String()
-					}
-				}
-			}
+	for _, attr := range p.requiredAttributes[xmlNode.Name] {
+		if _, ok := xmlNode.Attributes[attr]; !ok {
+			return fmt.Errorf("<%s> requires attribute %q: %w", xmlNode.Name, attr, ErrMissingRequiredAttribute)
 		}
 	}
 	return nil
 }
 
+// processComment handles a complete comment according to the configured comment modes
+func (p *StreamXmlParser) processComment(value string, position int) {
+	mode := p.config.CommentMode
+	if p.depth > 0 {
+		mode = p.config.ElementCommentMode
+	} else if p.config.PreserveComments {
+		mode = CommentNode
+	}
+
+	switch mode {
+	case CommentText:
+		p.processText(value, position)
+	case CommentNode:
+		body := strings.TrimSuffix(strings.TrimPrefix(value, commentStart), commentEnd)
+		if p.depth == 0 {
+			p.astNodes = append(p.astNodes, ASTNode{
+				Type:     ASTNodeComment,
+				Text:     body,
+				Position: position,
+			})
+		} else if len(p.xmlStack) > 0 {
+			node := p.xmlStack[len(p.xmlStack)-1]
+			node.Comments = append(node.Comments, body)
+		}
+	}
+}
+
 // processCompleteTag processes a complete tag (reconstructed from tokens)
 func (p *StreamXmlParser) processCompleteTag() error {
 	if len(p.tagTokens) < 3 {
@@ -265,6 +1877,8 @@ func (p *StreamXmlParser) processCompleteTag() error {
 	isSelfClosing := false
 	elementName := ""
 	attributes := make(map[string]string)
+	var orderedAttributes []Attribute
+	attributesTruncated := false
 
 	i := 1 // Skip opening <
 
@@ -275,8 +1889,10 @@ func (p *StreamXmlParser) processCompleteTag() error {
 	}
 
 	// Get element name
+	rawAttributes := ""
 	if i < len(p.tagTokens) && p.tagTokens[i].Type == TokenElementName {
-		elementName = p.getValue(p.tagTokens[i])
+		elementName = p.tokenizer.rewriteName(p.getValue(p.tagTokens[i]))
+		rawAttributes = p.rawAttributes(p.tagTokens[i])
 		i++
 	}
 
@@ -298,7 +1914,19 @@ func (p *StreamXmlParser) processCompleteTag() error {
 
 				// Expect value
 				if i < len(p.tagTokens) && p.tagTokens[i].Type == TokenAttributeValue {
-					attributes[attrName] = p.getValue(p.tagTokens[i])
+					value := p.getValue(p.tagTokens[i])
+					if value == "" && p.config.WarnOnEmptyAttributeValue && !p.isQuotedValue(p.tagTokens[i]) {
+						p.addWarning(ErrEmptyAttributeValue, p.tagTokens[i].Start, attrName)
+					}
+					if p.config.TrimAttributeValues {
+						value = strings.TrimSpace(value)
+					}
+					if limit := p.config.MaxStoredAttributes; limit > 0 && len(orderedAttributes) >= limit {
+						attributesTruncated = true
+					} else {
+						attributes[attrName] = value
+						orderedAttributes = append(orderedAttributes, Attribute{Name: attrName, Value: value})
+					}
 					i++
 				}
 			}
@@ -307,21 +1935,67 @@ func (p *StreamXmlParser) processCompleteTag() error {
 		}
 	}
 
+	if !isClosing && elementName == "?xml" && p.depth == 0 && p.atDocumentStart() {
+		// The XML declaration is not an element
+		p.discardPartialNode()
+		p.declaration = attributes
+		return nil
+	}
+
+	if p.config.LenientTags && !isClosing && !isElementName(strings.TrimPrefix(elementName, "@")) {
+		// Drop just the malformed tag so it cannot swallow what follows
+		return p.processMalformedTag()
+	}
+
+	if p.config.RequireSpaceBeforeSelfClose && isSelfClosing && !spaceBeforeSelfClose(p.rawTag()) {
+		return p.processMalformedTag()
+	}
+
+	if !isClosing && p.depth == 0 && p.tagValidator != nil && !p.tagValidator(elementName, attributes) {
+		return p.keepTagAsText(TextifyUnvalidated)
+	}
+
+	// A stray closing tag at the top level never completes a partial node
+	if isClosing && p.partialTagPending {
+		p.discardPartialNode()
+	}
+	p.partialTagPending = false
+
+	if p.depth == 0 && p.textElement != "" && elementName == p.textElement && (!isClosing || p.inTextElement) {
+		// The text element is not a node: its tags are dropped and its content is text
+		p.discardPartialNode()
+		if !isSelfClosing {
+			p.inTextElement = !isClosing
+		}
+		return nil
+	}
+
 	// Process based on tag type
 	if isClosing {
 		// Closing tag
+		if p.depth > 1 && elementName == p.openNames[0] && elementName != p.openNames[p.depth-1] {
+			p.closeUnclosed()
+		}
 		if p.depth > 0 {
 			p.depth--
+			p.openNames = p.openNames[:p.depth]
+			if p.allowedWidened {
+				p.applyAllowedElements()
+			}
 		}
 
-		if p.depth == 0 && len(p.xmlStack) > 0 {
+		if p.depth == 0 && len(p.xmlStack) == 0 {
+			p.processStrayClose(elementName)
+		} else if p.depth == 0 && len(p.xmlStack) > 0 {
 			// Closing top-level tag
+			p.captureInnerXML(p.tagStartPos)
 			xmlNode := p.xmlStack[len(p.xmlStack)-1]
 			p.xmlStack = p.xmlStack[:len(p.xmlStack)-1]
 
 			xmlNode.Content = p.currentContent.String()
 			xmlNode.EndPos = p.tagStartPos
 			xmlNode.Partial = false
+			p.checkJSONContent(xmlNode)
 
 			// Update existing node if it was partial, or add new one
 			if p.currentPartialNode == xmlNode && p.partialNodeIndex >= 0 {
@@ -330,22 +2004,19 @@ func (p *StreamXmlParser) processCompleteTag() error {
 				p.partialNodeIndex = -1
 			} else {
 				// Add to AST
-				p.astNodes = append(p.astNodes, ASTNode{
-					Type:     ASTNodeXml,
-					XmlNode:  xmlNode,
-					Position: xmlNode.StartPos,
-				})
+				p.appendXmlNode(xmlNode, xmlNode.StartPos)
 			}
 
 			// Reset content builder
 			p.currentContent.Reset()
+			return p.completeNode(xmlNode)
+		} else if p.depth > 0 && p.config.ChildNodes && len(p.xmlStack) > 1 {
+			p.closeChild()
+			p.captureInnerXML(p.tagEnd())
 		} else if p.depth > 0 {
 			// Nested closing tag - add to content as raw text
-			p.currentContent.WriteString(p.reconstructTag())
-			// Update content in current open node
-			if len(p.xmlStack) > 0 {
-				p.xmlStack[len(p.xmlStack)-1].Content = p.currentContent.String()
-			}
+			p.writeContent(p.rawTag())
+			p.captureInnerXML(p.tagEnd())
 		}
 	} else if isSelfClosing {
 		// Self-closing tag
@@ -355,33 +2026,49 @@ func (p *StreamXmlParser) processCompleteTag() error {
 				// Update existing partial node
 				p.currentPartialNode.Name = elementName
 				p.currentPartialNode.Attributes = attributes
+				p.currentPartialNode.OrderedAttributes = orderedAttributes
+				p.currentPartialNode.AttributesTruncated = attributesTruncated
+				p.currentPartialNode.RawAttributes = rawAttributes
 				p.currentPartialNode.Partial = false
+				p.currentPartialNode.SelfClosing = !p.config.NormalizeEmptyElements
 				p.currentPartialNode.EndPos = p.tagStartPos
+				p.applyElementOptions(p.currentPartialNode)
+				xmlNode := p.currentPartialNode
 				p.currentPartialNode = nil
 				p.partialNodeIndex = -1
+				p.startNode(xmlNode)
+				return p.completeNode(xmlNode)
 			} else {
 				xmlNode := &XmlNode{
-					Name:       elementName,
-					Attributes: attributes,
-					Partial:    false,
-					Content:    "",
-					StartPos:   p.tagStartPos,
-					EndPos:     p.tagStartPos,
+					Ordinal:             p.nextOrdinal(),
+					PrecedingText:       p.precedingText(),
+					Name:                elementName,
+					Attributes:          attributes,
+					OrderedAttributes:   orderedAttributes,
+					AttributesTruncated: attributesTruncated,
+					RawAttributes:       rawAttributes,
+					Partial:             false,
+					SelfClosing:         !p.config.NormalizeEmptyElements,
+					Content:             "",
+					StartPos:            p.tagStartPos,
+					EndPos:              p.tagStartPos,
 				}
+				p.applyElementOptions(xmlNode)
 
-				p.astNodes = append(p.astNodes, ASTNode{
-					Type:     ASTNodeXml,
-					XmlNode:  xmlNode,
-					Position: p.tagStartPos,
-				})
+				p.appendXmlNode(xmlNode, p.tagStartPos)
+				p.startNode(xmlNode)
+				return p.completeNode(xmlNode)
 			}
+		} else if p.config.ChildNodes {
+			child := p.addChild(elementName, attributes, orderedAttributes, attributesTruncated, rawAttributes)
+			child.Partial = false
+			child.SelfClosing = !p.config.NormalizeEmptyElements
+			child.EndPos = p.tagStartPos
+			p.captureInnerXML(p.tagEnd())
 		} else {
 			// Nested self-closing tag - add to content as raw text
-			p.currentContent.WriteString(p.reconstructTag())
-			// Update content in current open node
-			if len(p.xmlStack) > 0 {
-				p.xmlStack[len(p.xmlStack)-1].Content = p.currentContent.String()
-			}
+			p.writeContent(p.rawTag())
+			p.captureInnerXML(p.tagEnd())
 		}
 	} else {
 		// Opening tag
@@ -391,33 +2078,42 @@ func (p *StreamXmlParser) processCompleteTag() error {
 				// Update existing partial node with complete info
 				p.currentPartialNode.Name = elementName
 				p.currentPartialNode.Attributes = attributes
+				p.currentPartialNode.OrderedAttributes = orderedAttributes
+				p.currentPartialNode.AttributesTruncated = attributesTruncated
+				p.currentPartialNode.RawAttributes = rawAttributes
+				p.applyElementOptions(p.currentPartialNode)
 
 				// Push to stack if not already there
 				if len(p.xmlStack) == 0 || p.xmlStack[len(p.xmlStack)-1] != p.currentPartialNode {
 					p.xmlStack = append(p.xmlStack, p.currentPartialNode)
 					p.currentContent.Reset()
+					p.startInnerXML(p.currentPartialNode, p.tagEnd())
+					p.startNode(p.currentPartialNode)
 					p.depth++
+					p.openNames = append(p.openNames, elementName)
 
 					// Check max depth
-					if p.depth > p.config.MaxDepth {
-						return ErrMaxDepthExceeded
+					if err := p.checkDepth(); err != nil {
+						return err
 					}
 				}
 			} else {
 				// Top-level tag - create new XML node
 				xmlNode := &XmlNode{
-					Name:       elementName,
-					Attributes: attributes,
-					Partial:    true,
-					StartPos:   p.tagStartPos,
+					Ordinal:             p.nextOrdinal(),
+					PrecedingText:       p.precedingText(),
+					Name:                elementName,
+					Attributes:          attributes,
+					OrderedAttributes:   orderedAttributes,
+					AttributesTruncated: attributesTruncated,
+					RawAttributes:       rawAttributes,
+					Partial:             true,
+					StartPos:            p.tagStartPos,
 				}
+				p.applyElementOptions(xmlNode)
 
 				// Add to AST immediately
-				p.astNodes = append(p.astNodes, ASTNode{
-					Type:     ASTNodeXml,
-					XmlNode:  xmlNode,
-					Position: p.tagStartPos,
-				})
+				p.appendXmlNode(xmlNode, p.tagStartPos)
 
 				// Track as current partial node
 				p.currentPartialNode = xmlNode
@@ -426,59 +2122,177 @@ func (p *StreamXmlParser) processCompleteTag() error {
 				// Push to stack for tracking
 				p.xmlStack = append(p.xmlStack, xmlNode)
 				p.currentContent.Reset()
+				p.startInnerXML(xmlNode, p.tagEnd())
+				p.startNode(xmlNode)
 				p.depth++
+				p.openNames = append(p.openNames, elementName)
 
 				// Check max depth
-				if p.depth > p.config.MaxDepth {
-					return ErrMaxDepthExceeded
+				if err := p.checkDepth(); err != nil {
+					return err
 				}
 			}
 		} else {
-			// Nested tag - add to content as raw text
-			p.currentContent.WriteString(p.reconstructTag())
-			// Update content in current open node
-			if len(p.xmlStack) > 0 {
-				p.xmlStack[len(p.xmlStack)-1].Content = p.currentContent.String()
+			if p.config.ChildNodes {
+				p.openChild(p.addChild(elementName, attributes, orderedAttributes, attributesTruncated, rawAttributes))
+			} else {
+				// Nested tag - add to content as raw text
+				p.writeContent(p.rawTag())
 			}
+			p.captureInnerXML(p.tagEnd())
 			p.depth++
+			p.openNames = append(p.openNames, elementName)
 
 			// Check max depth
-			if p.depth > p.config.MaxDepth {
-				return ErrMaxDepthExceeded
+			if err := p.checkDepth(); err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
 
-// reconstructTag reconstructs the full tag string from collected tokens
-func (p *StreamXmlParser) reconstructTag() string {
-	var result strings.Builder
+// addChild adds a partial child element to the innermost open element
+func (p *StreamXmlParser) addChild(name string, attributes map[string]string, ordered []Attribute, truncated bool, rawAttributes string) *XmlNode {
+	parent := p.xmlStack[len(p.xmlStack)-1]
+	child := &XmlNode{
+		Name:                name,
+		Attributes:          attributes,
+		OrderedAttributes:   ordered,
+		AttributesTruncated: truncated,
+		RawAttributes:       rawAttributes,
+		Partial:             true,
+		StartPos:            p.tagStartPos,
+		InnerStartPos:       p.tagEnd(),
+		parent:              parent,
+		childOffset:         p.currentContent.Len(),
+	}
+	p.applyElementOptions(child)
+	parent.Children = append(parent.Children, child)
+	return child
+}
 
-	for _, token := range p.tagTokens {
-		value := p.getValue(token)
-		switch token.Type {
-		case TokenOpenBracket:
-			result.WriteString("<")
-		case TokenCloseBracket:
-			result.WriteString(">")
-		case TokenSlash:
-			result.WriteString("/")
-		case TokenElementName:
-			result.WriteString(value)
-		case TokenAttributeName:
-			result.WriteString(" ")
-			result.WriteString(value)
-		case TokenEquals:
-			result.WriteString("=")
-		case TokenAttributeValue:
-			result.WriteString("\"")
-			result.WriteString(value)
-			result.WriteString("\"")
-		}
-	}
-
-	return result.String()
+// openChild makes content flow into child until its closing tag
+func (p *StreamXmlParser) openChild(child *XmlNode) {
+	p.parentContent = append(p.parentContent, p.currentContent.String())
+	p.currentContent.Reset()
+	p.xmlStack = append(p.xmlStack, child)
+}
+
+// closeChild completes the innermost open child and makes content flow into
+// its parent again
+func (p *StreamXmlParser) closeChild() {
+	child := p.xmlStack[len(p.xmlStack)-1]
+	p.xmlStack = p.xmlStack[:len(p.xmlStack)-1]
+	child.Content = p.currentContent.String()
+	child.EndPos = p.tagStartPos
+	child.Partial = false
+	child.textBuffers = nil
+	p.checkJSONContent(child)
+
+	last := len(p.parentContent) - 1
+	p.currentContent.Reset()
+	p.currentContent.WriteString(p.parentContent[last])
+	p.parentContent[last] = ""
+	p.parentContent = p.parentContent[:last]
+}
+
+// processMalformedTag handles a complete tag that must not become an element.
+// With LenientTags it is dropped with an ErrMalformedTag warning; otherwise it
+// is kept as text.
+func (p *StreamXmlParser) processMalformedTag() error {
+	if p.config.LenientTags {
+		if p.partialTagPending {
+			p.discardPartialNode()
+		}
+		p.addWarning(ErrMalformedTag, p.tagStartPos, p.rawTag())
+		return nil
+	}
+	return p.keepTagAsText(TextifyMalformed)
+}
+
+// keepTagAsText handles a complete tag that must not become an element by
+// keeping it as text, recording reason in TextifiedTags
+func (p *StreamXmlParser) keepTagAsText(reason TextifyReason) error {
+	tag := p.rawTag()
+	if p.partialTagPending {
+		p.discardPartialNode()
+	}
+	p.addTextified(reason, p.tagStartPos, tag)
+	p.processText(tag, p.tagStartPos)
+	if p.depth > 0 {
+		p.captureInnerXML(p.tagEnd())
+	}
+	return nil
+}
+
+// spaceBeforeSelfClose reports whether the "/>" ending a self-closing tag
+// follows whitespace, as in <tool />
+func spaceBeforeSelfClose(tag string) bool {
+	r, _ := utf8.DecodeLastRuneInString(strings.TrimSuffix(tag, "/>"))
+	return unicode.IsSpace(r)
+}
+
+// atDocumentStart reports whether only whitespace and the pending tag have been seen
+func (p *StreamXmlParser) atDocumentStart() bool {
+	nodes := p.nodeCount
+	if p.partialTagPending && p.currentPartialNode != nil {
+		nodes--
+	}
+	return nodes == 0 && !p.preambleText
+}
+
+// tagEnd returns the position just after the closing > of the collected tag
+func (p *StreamXmlParser) tagEnd() int {
+	return p.tagTokens[len(p.tagTokens)-1].End
+}
+
+// processStrayClose handles a closing tag outside any element according to StrayCloseMode
+func (p *StreamXmlParser) processStrayClose(elementName string) {
+	switch p.config.StrayCloseMode {
+	case StrayCloseText:
+		p.addTextified(TextifyStrayClose, p.tagStartPos, elementName)
+		p.processText(p.rawTag(), p.tagStartPos)
+	case StrayCloseWarn:
+		p.addWarning(ErrStrayClosingTag, p.tagStartPos, elementName)
+	}
+}
+
+// rawAttributes returns the tag text between the element name and the closing > or />
+func (p *StreamXmlParser) rawAttributes(nameToken *Token) string {
+	end := p.tagTokens[len(p.tagTokens)-1].Start
+	if len(p.tagTokens) >= 2 {
+		if prev := p.tagTokens[len(p.tagTokens)-2]; prev.Type == TokenSlash && prev.Start > nameToken.Start {
+			end = prev.Start
+		}
+	}
+
+	buffer := p.tokenizer.GetBuffer()
+	if nameToken.End < 0 || end > len(buffer) || nameToken.End > end {
+		return ""
+	}
+	return buffer[nameToken.End:end]
+}
+
+// closeUnclosed leaves the nested elements still open when a closing tag for
+// the top-level element arrives, e.g. the "<b && c>" in
+// "<expr>a<b && c>d</expr>", so that the closing tag ends the top-level element.
+// With ChildNodes the abandoned children stay partial.
+func (p *StreamXmlParser) closeUnclosed() {
+	for p.depth > 1 {
+		if p.config.ChildNodes && len(p.xmlStack) > 1 {
+			child := p.xmlStack[len(p.xmlStack)-1]
+			p.closeChild()
+			child.Partial = true
+		}
+		p.depth--
+	}
+	p.openNames = p.openNames[:p.depth]
+}
+
+// rawTag returns the collected tag exactly as it was written
+func (p *StreamXmlParser) rawTag() string {
+	return p.tokenizer.GetBuffer()[p.tagStartPos:p.tagEnd()]
 }
 
 // GetText returns all accumulated text (excluding XML tags)
@@ -490,7 +2304,7 @@ func (p *StreamXmlParser) GetText() (string, error) {
 	var result strings.Builder
 
 	for _, node := range p.astNodes {
-		if node.Type == ASTNodeText {
+		if node.Type.isText() {
 			result.WriteString(node.Text)
 		}
 	}
@@ -498,7 +2312,154 @@ func (p *StreamXmlParser) GetText() (string, error) {
 	return result.String(), nil
 }
 
-// GetXmlNode returns the first XML node (complete or partial)
+// DeclaredEncoding returns the encoding pseudo-attribute of an XML
+// declaration such as <?xml version="1.0" encoding="UTF-16"?> at the start of
+// the stream. It is informational only: input is always parsed as the string
+// it was appended as. It reports false if there is no declaration or it has
+// no encoding. The declaration itself is not an XML node.
+// This method is thread-safe.
+func (p *StreamXmlParser) DeclaredEncoding() (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	encoding, ok := p.declaration["encoding"]
+	return encoding, ok
+}
+
+// Preamble returns the top-level text that appeared before the first XML node,
+// e.g. the prose in front of a tool call. Text after the first node is not
+// included. ClearText clears it too, so a text-only stream does not keep a
+// second copy of its text.
+// This method is thread-safe.
+func (p *StreamXmlParser) Preamble() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.preamble.String()
+}
+
+// textEscaper escapes the characters that are significant in HTML text
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// GetTextEscaped returns GetText with '<', '>' and '&' HTML-escaped, so text
+// containing tag-like content can be placed into HTML safely.
+// This method is thread-safe.
+func (p *StreamXmlParser) GetTextEscaped() string {
+	text, _ := p.GetText()
+	return textEscaper.Replace(text)
+}
+
+// ClearText discards all accumulated text while leaving XML nodes and the
+// current parse state intact. GetText afterwards returns only text appended since.
+// This method is thread-safe.
+func (p *StreamXmlParser) ClearText() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.filterAST(func(node ASTNode) bool {
+		return !node.Type.isText()
+	})
+	p.textParts = p.textParts[:0]
+	p.preamble.Reset()
+}
+
+// DrainCompletedNodes removes all complete XML nodes from the AST and returns
+// copies of them in document order. Partial nodes and text are kept.
+// This method is thread-safe.
+func (p *StreamXmlParser) DrainCompletedNodes() []*XmlNode {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	drained := make([]*XmlNode, 0)
+	p.filterAST(func(node ASTNode) bool {
+		if node.Type == ASTNodeXml && node.XmlNode != nil && !node.XmlNode.Partial {
+			drained = append(drained, node.XmlNode.clone())
+			return false
+		}
+		return true
+	})
+	return drained
+}
+
+// NewCompletedNodes returns copies of the nodes that completed since the previous call,
+// in document order, so each node is returned once. It is the polling
+// alternative to sinks and Events. Nodes removed by DrainCompletedNodes before
+// they were polled are not returned.
+// This method is thread-safe.
+func (p *StreamXmlParser) NewCompletedNodes() []*XmlNode {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Nodes are in ordinal order, so only the tail of the AST can be new
+	start := len(p.astNodes)
+	for start > 0 {
+		node := p.astNodes[start-1]
+		if node.Type == ASTNodeXml && node.XmlNode.Ordinal <= p.polledOrdinal {
+			break
+		}
+		start--
+	}
+
+	var nodes []*XmlNode
+	for _, node := range p.astNodes[start:] {
+		if node.Type != ASTNodeXml {
+			continue
+		}
+		if node.XmlNode.Partial {
+			break
+		}
+		nodes = append(nodes, node.XmlNode.clone())
+		p.polledOrdinal = node.XmlNode.Ordinal
+	}
+	return nodes
+}
+
+// filterAST keeps only the AST nodes for which keep returns true
+func (p *StreamXmlParser) filterAST(keep func(ASTNode) bool) {
+	nodes := p.astNodes[:0]
+	for _, node := range p.astNodes {
+		if !keep(node) {
+			continue
+		}
+		if node.XmlNode == p.currentPartialNode && p.partialNodeIndex >= 0 {
+			p.partialNodeIndex = len(nodes)
+		}
+		nodes = append(nodes, node)
+	}
+	// Clear the tail so dropped nodes can be collected
+	for i := len(nodes); i < len(p.astNodes); i++ {
+		p.astNodes[i] = ASTNode{}
+	}
+	p.astNodes = nodes
+}
+
+// estimatedASTNodeSize approximates the memory held by one AST entry,
+// including its XmlNode, attribute map and string headers
+const estimatedASTNodeSize = 256
+
+// ApproxMemoryUsage returns a rough estimate in bytes of the memory held by the
+// parser: the tokenizer buffer, the AST and the content being accumulated.
+// It is cheap to call and meant for deciding when to drain, not for accounting.
+// This method is thread-safe.
+func (p *StreamXmlParser) ApproxMemoryUsage() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	usage := len(p.tokenizer.GetBuffer())
+	usage += len(p.astNodes) * estimatedASTNodeSize
+	usage += p.currentContent.Cap() + p.innerXML.Cap()
+	return usage
+}
+
+// HasXml reports whether any XML node (partial or complete) has been produced,
+// even if it was since drained
+// This method is thread-safe.
+func (p *StreamXmlParser) HasXml() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.hasXml
+}
+
+// GetXmlNode returns a copy of the first XML node (complete or partial)
 // This method is thread-safe.
 func (p *StreamXmlParser) GetXmlNode() (*XmlNode, error) {
 	p.mu.RLock()
@@ -506,13 +2467,69 @@ func (p *StreamXmlParser) GetXmlNode() (*XmlNode, error) {
 
 	for _, node := range p.astNodes {
 		if node.Type == ASTNodeXml && node.XmlNode != nil {
-			return node.XmlNode, nil
+			return node.XmlNode.clone(), nil
 		}
 	}
 	return nil, nil
 }
 
-// GetXmlNodes returns all XML nodes (complete and partial)
+// CurrentElement returns a copy of the element that content currently flows
+// into, or nil when the parser is at the top level.
+// This method is thread-safe.
+func (p *StreamXmlParser) CurrentElement() *XmlNode {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.depth == 0 || len(p.xmlStack) == 0 {
+		return nil
+	}
+	return p.xmlStack[len(p.xmlStack)-1].clone()
+}
+
+// OpenContentLen returns the length in bytes of the content accumulated so far
+// by the open element, including nested markup, or 0 at the top level. It can
+// be polled to flush content at a size threshold.
+// This method is thread-safe.
+func (p *StreamXmlParser) OpenContentLen() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.depth == 0 {
+		return 0
+	}
+	return p.currentContent.Len()
+}
+
+// State returns where the parser currently is in the stream, e.g. to show a
+// spinner while a tag is being received. An unfinished comment or CDATA
+// section is not a tag and reports the surrounding state.
+// This method is thread-safe.
+func (p *StreamXmlParser) State() ParserState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.tokenizer.inTag {
+		tag := p.tokenizer.tagBuffer.String()
+		if strings.HasPrefix(tag, "</") {
+			return StateInClosingTag
+		}
+		if !isSectionPrefix(tag) {
+			return StateInTag
+		}
+	} else if p.collectingTag {
+		if len(p.tagTokens) > 1 && p.tagTokens[1].Type == TokenSlash {
+			return StateInClosingTag
+		}
+		return StateInTag
+	}
+	if p.depth > 0 {
+		return StateInContent
+	}
+	return StateText
+}
+
+// GetXmlNodes returns copies of all XML nodes (complete and partial). The
+// copies do not change as parsing continues; call it again for updates.
 // This method is thread-safe.
 func (p *StreamXmlParser) GetXmlNodes() ([]*XmlNode, error) {
 	p.mu.RLock()
@@ -522,14 +2539,86 @@ func (p *StreamXmlParser) GetXmlNodes() ([]*XmlNode, error) {
 
 	for _, node := range p.astNodes {
 		if node.Type == ASTNodeXml && node.XmlNode != nil {
-			nodes = append(nodes, node.XmlNode)
+			nodes = append(nodes, node.XmlNode.clone())
 		}
 	}
 
 	return nodes, nil
 }
 
-// GetAST returns the complete AST
+// LiveNodes returns the parser's own XML nodes (complete and partial). It is
+// the only method returning them; all others return copies that may be kept
+// and changed. Unlike GetXmlNodes, a partial node keeps updating in place as data is appended,
+// which suits progressive rendering. The nodes must be treated as read-only,
+// and fields of a partial node may change concurrently with Append, so reads
+// must not race with it.
+// This method is thread-safe.
+func (p *StreamXmlParser) LiveNodes() []*XmlNode {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	nodes := make([]*XmlNode, 0)
+	for _, node := range p.astNodes {
+		if node.Type == ASTNodeXml && node.XmlNode != nil {
+			nodes = append(nodes, node.XmlNode)
+		}
+	}
+	return nodes
+}
+
+// HasElement reports whether a top-level element with the given name has been
+// seen, complete or partial, regardless of its content. This distinguishes an
+// empty <tool></tool> from no <tool> at all. Nodes removed by
+// DrainCompletedNodes no longer count.
+// This method is thread-safe.
+func (p *StreamXmlParser) HasElement(name string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, node := range p.astNodes {
+		if node.Type == ASTNodeXml && node.XmlNode != nil && node.XmlNode.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ToolCalls returns copies of the complete top-level nodes with the given name in
+// document order. Back-to-back elements such as <tool>{...}</tool><tool>{...}</tool>
+// are always separate nodes, with or without text between them; a node that is
+// still streaming is not included until it completes.
+// This method is thread-safe.
+func (p *StreamXmlParser) ToolCalls(name string) []*XmlNode {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	nodes := make([]*XmlNode, 0)
+	for _, node := range p.astNodes {
+		if node.Type == ASTNodeXml && node.XmlNode != nil && !node.XmlNode.Partial && node.XmlNode.Name == name {
+			nodes = append(nodes, node.XmlNode.clone())
+		}
+	}
+	return nodes
+}
+
+// NodesByName returns copies of all XML nodes, complete and partial, grouped
+// by element name, in document order within each group, e.g. to build a
+// dispatch table. Like GetXmlNodes, call it again to see updates.
+// This method is thread-safe.
+func (p *StreamXmlParser) NodesByName() map[string][]*XmlNode {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	groups := make(map[string][]*XmlNode)
+	for _, node := range p.astNodes {
+		if node.Type == ASTNodeXml && node.XmlNode != nil {
+			groups[node.XmlNode.Name] = append(groups[node.XmlNode.Name], node.XmlNode.clone())
+		}
+	}
+	return groups
+}
+
+// GetAST returns a copy of the complete AST, including copies of its XML nodes
 // This method is thread-safe.
 func (p *StreamXmlParser) GetAST() []ASTNode {
 	p.mu.RLock()
@@ -538,39 +2627,125 @@ func (p *StreamXmlParser) GetAST() []ASTNode {
 	// Return a copy to prevent external modification
 	result := make([]ASTNode, len(p.astNodes))
 	copy(result, p.astNodes)
+	for i := range result {
+		if result[i].XmlNode != nil {
+			result[i].XmlNode = result[i].XmlNode.clone()
+		}
+	}
 	return result
 }
 
-// extractPartialTagName tries to extract tag name from incomplete tag
+// Fingerprint returns a hash of the parse result: the text between nodes,
+// comments, and each XML node's name, attributes (sorted by name), content,
+// state and children. It does not depend on how the input was split into chunks, so the
+// same document fed whole or byte by byte has the same fingerprint.
+// This method is thread-safe.
+func (p *StreamXmlParser) Fingerprint() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	h := fnv.New64a()
+	var size [8]byte
+	write := func(kind byte, value string) {
+		binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+		h.Write([]byte{kind})
+		h.Write(size[:])
+		h.Write([]byte(value))
+	}
+
+	// Adjacent text nodes depend on chunking, so runs of text are hashed whole
+	var text strings.Builder
+	flushText := func() {
+		if text.Len() > 0 {
+			write('t', text.String())
+			text.Reset()
+		}
+	}
+
+	var writeNode func(xmlNode *XmlNode)
+	writeNode = func(xmlNode *XmlNode) {
+		write('n', xmlNode.Name)
+		for _, key := range slices.Sorted(maps.Keys(xmlNode.Attributes)) {
+			write('k', key)
+			write('v', xmlNode.Attributes[key])
+		}
+		write('b', xmlNode.Content)
+		write('s', fmt.Sprint(xmlNode.Partial, xmlNode.SelfClosing))
+		for _, child := range xmlNode.Children {
+			write('o', strconv.Itoa(child.childOffset))
+			writeNode(child)
+		}
+		write('e', "")
+	}
+
+	for _, node := range p.astNodes {
+		switch {
+		case node.Type.isText():
+			text.WriteString(node.Text)
+		case node.Type == ASTNodeComment:
+			flushText()
+			write('c', node.Text)
+		case node.XmlNode != nil:
+			flushText()
+			writeNode(node.XmlNode)
+		}
+	}
+	flushText()
+	return h.Sum64()
+}
+
+// extractPartialTagName tries to extract tag name from incomplete tag.
+// Only the leading name is scanned so the cost does not grow with the tag.
 func extractPartialTagName(tagValue string) string {
 	if len(tagValue) < 2 {
 		return ""
 	}
 
-	// Remove leading <
-	content := strings.TrimPrefix(tagValue, "<")
-	content = strings.TrimSpace(content)
+	// Skip leading < and whitespace
+	start := skipSpace(tagValue, 1)
 
 	// Extract first word as tag name
-	parts := strings.Fields(content)
-	if len(parts) > 0 {
-		return parts[0]
+	end := start
+	for end < len(tagValue) && !isSpaceAt(tagValue, end) {
+		end++
 	}
-
-	return content
+	return tagValue[start:end]
 }
 
-// isClosingTagFragment checks if an incomplete token value looks like a closing tag fragment
-func isClosingTagFragment(value string) bool {
-	if len(value) == 0 {
+// namesElement reports whether an incomplete tag starts with an element name
+// directly after the '<' that is valid and, if an allowed set is configured, allowed
+func (p *StreamXmlParser) namesElement(tagValue string) bool {
+	if len(tagValue) < 2 || isSpaceAt(tagValue, 1) {
+		return false
+	}
+	name := strings.TrimSuffix(extractPartialTagName(tagValue), "/")
+	if !isElementName(strings.TrimPrefix(name, "@")) {
 		return false
 	}
+	allowed := p.tokenizer.allowedElements
+	return allowed == nil || allowed[p.tokenizer.rewriteName(name)]
+}
 
-	// Only filter out if it's clearly a closing tag: "</", "</t", "</ta", etc.
-	// A single "<" might just be content, so don't filter it
-	if len(value) >= 2 && value[0] == '<' && value[1] == '/' {
-		return true
+// isElementName checks that a name starts with a letter, '_' or ':' and
+// continues with letters, digits, '-', '.', '_' or ':'
+func isElementName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, ch := range name {
+		switch {
+		case unicode.IsLetter(ch), ch == '_', ch == ':':
+		case i > 0 && (unicode.IsDigit(ch) || ch == '-' || ch == '.'):
+		default:
+			return false
+		}
 	}
+	return true
+}
 
-	return false
+// isClosingTagFragment checks if an incomplete token value looks like a closing tag fragment
+func isClosingTagFragment(value string) bool {
+	// Only "</", "</t", "</ta", etc. are clearly closing tags
+	// A single "<" might just be content
+	return len(value) >= 2 && value[0] == '<' && value[1] == '/'
 }
@@ -15,7 +15,11 @@
 package streamxml
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"unsafe"
 )
 
 // TestMultiRoundAppendTextOnly tests appending text in multiple rounds
@@ -550,3 +554,3309 @@ func TestMultiRoundAppendEmptyContent(t *testing.T) {
 		}
 	}
 }
+
+// TestClearText tests clearing text while XML parsing continues
+func TestClearText(t *testing.T) {
+	parser := NewStreamXmlParser()
+
+	parser.Append("Some prose <tool name=\"a\">first")
+	parser.ClearText()
+
+	text, _ := parser.GetText()
+	if text != "" {
+		t.Errorf("expected empty text after ClearText, got '%s'", text)
+	}
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 1 || !nodes[0].Partial {
+		t.Fatalf("expected 1 partial node after ClearText")
+	}
+
+	parser.Append(" content</tool> more text <tool name=\"b\">second</tool>")
+
+	text, _ = parser.GetText()
+	if text != " more text " {
+		t.Errorf("expected ' more text ', got '%s'", text)
+	}
+	nodes, _ = parser.GetXmlNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	if nodes[0].Partial || nodes[0].Content != "first content" {
+		t.Errorf("expected complete first node with content 'first content', got '%s'", nodes[0].Content)
+	}
+	if nodes[1].Attributes["name"] != "b" || nodes[1].Content != "second" {
+		t.Errorf("expected second node b with content 'second', got '%s'", nodes[1].Content)
+	}
+}
+
+// TestCommentInsideElementModes tests each comment mode for comments inside an element
+func TestCommentInsideElementModes(t *testing.T) {
+	tests := []struct {
+		name            string
+		mode            CommentMode
+		expectedContent string
+		expectedComment []string
+	}{
+		{"drop", CommentDrop, "a  b", nil},
+		{"text", CommentText, "a <!-- note --> b", nil},
+		{"node", CommentNode, "a  b", []string{" note "}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.ElementCommentMode = tt.mode
+			parser := NewStreamXmlParserWithConfig(config)
+
+			parser.Append("Intro <!-- top --><tool>a <!-")
+			parser.Append("- note --")
+			parser.Append("> b</tool>")
+
+			text, _ := parser.GetText()
+			if text != "Intro " {
+				t.Errorf("expected top-level comment dropped, got text '%s'", text)
+			}
+			nodes, _ := parser.GetXmlNodes()
+			if len(nodes) != 1 {
+				t.Fatalf("expected 1 node, got %d", len(nodes))
+			}
+			if nodes[0].Content != tt.expectedContent {
+				t.Errorf("expected content '%s', got '%s'", tt.expectedContent, nodes[0].Content)
+			}
+			if len(nodes[0].Comments) != len(tt.expectedComment) {
+				t.Fatalf("expected comments %q, got %q", tt.expectedComment, nodes[0].Comments)
+			}
+			for i := range tt.expectedComment {
+				if nodes[0].Comments[i] != tt.expectedComment[i] {
+					t.Errorf("expected comment '%s', got '%s'", tt.expectedComment[i], nodes[0].Comments[i])
+				}
+			}
+		})
+	}
+}
+
+// TestCommentTopLevelNode tests capturing top-level comments as AST nodes
+func TestCommentTopLevelNode(t *testing.T) {
+	config := DefaultConfig()
+	config.CommentMode = CommentNode
+	parser := NewStreamXmlParserWithConfig(config)
+
+	parser.Append("Hi <!-- top --><tool>x</tool>")
+
+	ast := parser.GetAST()
+	if len(ast) != 3 {
+		t.Fatalf("expected 3 AST nodes, got %d", len(ast))
+	}
+	if ast[1].Type != ASTNodeComment || ast[1].Text != " top " {
+		t.Errorf("expected comment node ' top ', got %v '%s'", ast[1].Type, ast[1].Text)
+	}
+	text, _ := parser.GetText()
+	if text != "Hi " {
+		t.Errorf("expected text 'Hi ', got '%s'", text)
+	}
+}
+
+// TestCommentTopLevelSplit tests that a comment split across appends never
+// shows up as an element
+func TestCommentTopLevelSplit(t *testing.T) {
+	for _, mode := range []CommentMode{CommentDrop, CommentNode} {
+		config := DefaultConfig()
+		config.CommentMode = mode
+		parser := NewStreamXmlParserWithConfig(config)
+
+		for _, chunk := range []string{"a <!-", "- foo <b>--", "> c"} {
+			parser.Append(chunk)
+			if nodes, _ := parser.GetXmlNodes(); len(nodes) != 0 {
+				t.Errorf("mode %d, after %q: expected no XML nodes, got %q", mode, chunk, nodes[0].Name)
+			}
+		}
+		parser.Finalize()
+
+		text, _ := parser.GetText()
+		if text != "a  c" {
+			t.Errorf("mode %d: expected text 'a  c', got '%s'", mode, text)
+		}
+		var comments []string
+		for _, node := range parser.GetAST() {
+			if node.Type == ASTNodeComment {
+				comments = append(comments, node.Text)
+			}
+		}
+		if want := map[CommentMode]string{CommentNode: " foo <b>"}[mode]; strings.Join(comments, "|") != want {
+			t.Errorf("mode %d: expected comment nodes %q, got %q", mode, want, comments)
+		}
+	}
+}
+
+// TestPreserveComments tests receiving top-level comments as AST nodes
+func TestPreserveComments(t *testing.T) {
+	for _, preserve := range []bool{false, true} {
+		config := DefaultConfig()
+		config.PreserveComments = preserve
+		config.CommentMode = CommentText
+		parser := NewStreamXmlParserWithConfig(config)
+		for _, chunk := range []string{"a <!-", "- x --", "> b <tool><!-- y --></tool>"} {
+			parser.Append(chunk)
+		}
+		parser.Finalize()
+
+		var comments []string
+		for _, node := range parser.GetAST() {
+			if node.Type == ASTNodeComment {
+				comments = append(comments, node.Text)
+			}
+		}
+		text, _ := parser.GetText()
+		node, _ := parser.GetXmlNode()
+		if preserve {
+			if strings.Join(comments, "|") != " x " || text != "a  b " {
+				t.Errorf("expected a comment node instead of text, got %q and text %q", comments, text)
+			}
+		} else if len(comments) != 0 || text != "a <!-- x --> b " {
+			t.Errorf("expected the comment as text, got %q and text %q", comments, text)
+		}
+		if node == nil || node.Content != "" || len(node.Comments) != 0 {
+			t.Errorf("expected ElementCommentMode to apply inside elements, got %+v", node)
+		}
+	}
+}
+
+// TestFinalizeUnterminatedAttributeQuote tests the warning for a quote left open at end of stream
+func TestFinalizeUnterminatedAttributeQuote(t *testing.T) {
+	parser := NewStreamXmlParser()
+
+	parser.Append("Text <tool a=\"unterminated>")
+	parser.Append(" still value")
+
+	if len(parser.Warnings()) != 0 {
+		t.Errorf("expected no warnings before Finalize")
+	}
+	if err := parser.Finalize(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	warnings := parser.Warnings()
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %d", len(warnings))
+	}
+	if !errors.Is(warnings[0], ErrUnterminatedAttributeValue) {
+		t.Errorf("expected ErrUnterminatedAttributeValue, got %v", warnings[0].Err)
+	}
+	if warnings[0].Detail != "<tool a=\"unterminated> still value" {
+		t.Errorf("unexpected warning detail '%s'", warnings[0].Detail)
+	}
+
+	// Finalize is idempotent
+	parser.Finalize()
+	if len(parser.Warnings()) != 1 {
+		t.Errorf("expected warnings unchanged after second Finalize")
+	}
+}
+
+// TestFinalizeTerminatedAttributeQuote tests that a '>' inside a closed quote belongs to the value
+func TestFinalizeTerminatedAttributeQuote(t *testing.T) {
+	parser := NewStreamXmlParser()
+
+	parser.Append("<tool a=\"x>")
+	parser.Append("y\">content</tool>")
+	parser.Finalize()
+
+	if len(parser.Warnings()) != 0 {
+		t.Errorf("expected no warnings, got %v", parser.Warnings())
+	}
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 1 {
+		t.Fatalf("expected 1 node, got %d", len(nodes))
+	}
+	if nodes[0].Attributes["a"] != "x>y" {
+		t.Errorf("expected attribute a='x>y', got '%s'", nodes[0].Attributes["a"])
+	}
+	if nodes[0].Content != "content" {
+		t.Errorf("expected content 'content', got '%s'", nodes[0].Content)
+	}
+}
+
+// TestFinalizeTrailingPartialTag tests that Finalize keeps a trailing partial
+// tag only when it names an element
+func TestFinalizeTrailingPartialTag(t *testing.T) {
+	tests := []struct {
+		input   string
+		allowed []string
+		node    string // expected partial node name, "" for none
+		text    string
+	}{
+		{"call <tool", nil, "tool", "call "},
+		{"call <tool name=\"x", nil, "tool", "call "},
+		{"call <tool/", nil, "tool/", "call "},
+		{"run <@set", nil, "@set", "run "},
+		{"trailing a < b", nil, "", "trailing a < b"},
+		{"so 1 <3", nil, "", "so 1 <3"},
+		{"end <", nil, "", "end <"},
+		{"call <tool", []string{"tool"}, "tool", "call "},
+		{"call <to", []string{"tool"}, "", "call <to"},
+	}
+
+	for _, tt := range tests {
+		parser := NewStreamXmlParser()
+		if tt.allowed != nil {
+			parser.SetAllowedElements(tt.allowed)
+		}
+		parser.Append(tt.input)
+		parser.Finalize()
+
+		nodes, _ := parser.GetXmlNodes()
+		if tt.node == "" {
+			if len(nodes) != 0 {
+				t.Errorf("%q: expected no nodes, got %d", tt.input, len(nodes))
+			}
+		} else if len(nodes) != 1 || !nodes[0].Partial || nodes[0].Name != tt.node {
+			t.Errorf("%q: expected partial node %q, got %v", tt.input, tt.node, nodes)
+		}
+		if text, _ := parser.GetText(); text != tt.text {
+			t.Errorf("%q: expected text %q, got %q", tt.input, tt.text, text)
+		}
+	}
+}
+
+// TestNodeOrdinalsSurviveDraining tests that ordinals stay contiguous and stable across drains
+func TestNodeOrdinalsSurviveDraining(t *testing.T) {
+	parser := NewStreamXmlParser()
+
+	parser.Append("a<tool>1</tool>b<tool>2</tool><to")
+	drained := parser.DrainCompletedNodes()
+	if len(drained) != 2 {
+		t.Fatalf("expected 2 drained nodes, got %d", len(drained))
+	}
+
+	parser.Append("ol>3</tool><self/>")
+	parser.Append("<tool>4</tool>")
+	drained = append(drained, parser.DrainCompletedNodes()...)
+
+	if len(drained) != 5 {
+		t.Fatalf("expected 5 drained nodes, got %d", len(drained))
+	}
+	for i, node := range drained {
+		if node.Ordinal != i {
+			t.Errorf("node %d (%s): expected ordinal %d, got %d", i, node.Content, i, node.Ordinal)
+		}
+	}
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 0 {
+		t.Errorf("expected no nodes left after draining, got %d", len(nodes))
+	}
+	text, _ := parser.GetText()
+	if text != "ab" {
+		t.Errorf("expected text 'ab' to be kept, got '%s'", text)
+	}
+}
+
+// recordingSink records sink events as strings
+type recordingSink struct {
+	events []string
+}
+
+func (s *recordingSink) Node(node *XmlNode) {
+	s.events = append(s.events, "node:"+node.Name+"="+node.Content)
+}
+
+func (s *recordingSink) Text(text string) {
+	s.events = append(s.events, "text:"+text)
+}
+
+func (s *recordingSink) Done() {
+	s.events = append(s.events, "done")
+}
+
+// TestSinksReceiveEvents tests that multiple sinks receive identical event sequences
+func TestSinksReceiveEvents(t *testing.T) {
+	parser := NewStreamXmlParser()
+	first := &recordingSink{}
+	second := &recordingSink{}
+	parser.AddSink(first)
+	parser.AddSink(second)
+
+	parser.Append("Hello <tool>q")
+	parser.Append("uery</tool> bye<ping/>")
+	parser.Finalize()
+
+	expected := []string{"text:Hello ", "node:tool=query", "text: bye", "node:ping=", "done"}
+	for _, sink := range []*recordingSink{first, second} {
+		if len(sink.events) != len(expected) {
+			t.Fatalf("expected events %q, got %q", expected, sink.events)
+		}
+		for i := range expected {
+			if sink.events[i] != expected[i] {
+				t.Errorf("event %d: expected '%s', got '%s'", i, expected[i], sink.events[i])
+			}
+		}
+	}
+}
+
+// TestWarnOnEmptyAttributeValue tests distinguishing name="" from an ambiguous name=
+func TestWarnOnEmptyAttributeValue(t *testing.T) {
+	config := DefaultConfig()
+	config.WarnOnEmptyAttributeValue = true
+
+	// Explicitly empty value: no warning
+	parser := NewStreamXmlParserWithConfig(config)
+	parser.Append("<tool name=\"\" type=\"x\"></tool>")
+	if len(parser.Warnings()) != 0 {
+		t.Errorf("expected no warnings for name=\"\", got %v", parser.Warnings())
+	}
+
+	// Ambiguous value: warning, and the following attribute is still parsed
+	parser = NewStreamXmlParserWithConfig(config)
+	parser.Append("<tool name= type=\"x\"></tool>")
+	warnings := parser.Warnings()
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning for name=, got %d", len(warnings))
+	}
+	if !errors.Is(warnings[0], ErrEmptyAttributeValue) || warnings[0].Detail != "name" {
+		t.Errorf("unexpected warning %v", warnings[0])
+	}
+	node, _ := parser.GetXmlNode()
+	if node.Attributes["name"] != "" || node.Attributes["type"] != "x" {
+		t.Errorf("expected name='' and type='x', got %v", node.Attributes)
+	}
+
+	// Flag off: no warning
+	parser = NewStreamXmlParser()
+	parser.Append("<tool name=></tool>")
+	if len(parser.Warnings()) != 0 {
+		t.Errorf("expected no warnings with flag off, got %v", parser.Warnings())
+	}
+}
+
+// TestConfigReflectsEffectiveConfiguration tests reading back the active configuration
+func TestConfigReflectsEffectiveConfiguration(t *testing.T) {
+	// Invalid config falls back to defaults
+	parser := NewStreamXmlParserWithConfig(ParserConfig{MaxDepth: 0})
+	config := parser.Config()
+	defaults := DefaultConfig()
+	if config.MaxDepth != defaults.MaxDepth || config.MaxBufferSize != defaults.MaxBufferSize ||
+		config.BufferCleanupThreshold != defaults.BufferCleanupThreshold || config.AllowedElements != nil {
+		t.Errorf("expected default config after invalid input, got %+v", config)
+	}
+
+	// Valid config is kept
+	custom := DefaultConfig()
+	custom.MaxDepth = 7
+	custom.AllowedElements = []string{"tool"}
+	parser = NewStreamXmlParserWithConfig(custom)
+	config = parser.Config()
+	if config.MaxDepth != 7 || len(config.AllowedElements) != 1 || config.AllowedElements[0] != "tool" {
+		t.Errorf("expected custom config, got %+v", config)
+	}
+
+	// The returned config is a copy
+	config.AllowedElements[0] = "changed"
+	if parser.Config().AllowedElements[0] != "tool" {
+		t.Errorf("expected Config to return a copy")
+	}
+}
+
+// TestNewStreamXmlParserChecked tests the constructor that reports invalid configuration
+func TestNewStreamXmlParserChecked(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxDepth = 3
+	parser, err := NewStreamXmlParserChecked(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parser.Config().MaxDepth != 3 {
+		t.Errorf("expected MaxDepth 3, got %d", parser.Config().MaxDepth)
+	}
+	parser.Append("<tool>ok</tool>")
+	if node, _ := parser.GetXmlNode(); node == nil || node.Content != "ok" {
+		t.Errorf("expected working parser")
+	}
+
+	config.MaxBufferSize = 10
+	parser, err = NewStreamXmlParserChecked(config)
+	if !errors.Is(err, ErrInvalidConfiguration) {
+		t.Errorf("expected ErrInvalidConfiguration, got %v", err)
+	}
+	if parser != nil {
+		t.Errorf("expected nil parser for invalid config")
+	}
+}
+
+// TestJSONContentElements tests streaming JSON well-formedness checks of element content
+func TestJSONContentElements(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.SetJSONContentElements([]string{"tool"})
+
+	// Valid JSON: undecided while streaming, valid on close
+	parser.Append("<tool>\n{\"query\": [1, ")
+	node, _ := parser.GetXmlNode()
+	if node.JSONValid != nil {
+		t.Errorf("expected undecided JSONValid for incomplete-but-valid content")
+	}
+	parser.Append("2]}\n</tool>")
+	node, _ = parser.GetXmlNode()
+	if node.JSONValid == nil || !*node.JSONValid {
+		t.Errorf("expected JSONValid=true after close")
+	}
+
+	// Invalid JSON detected before the element closes
+	parser.Append("<tool>{\"query\" 1")
+	nodes, _ := parser.GetXmlNodes()
+	second := nodes[1]
+	if second.JSONValid == nil || *second.JSONValid {
+		t.Errorf("expected JSONValid=false as soon as the error streams in")
+	}
+	parser.Append("}</tool>")
+	if second.JSONValid == nil || *second.JSONValid {
+		t.Errorf("expected JSONValid=false after close")
+	}
+
+	// Truncated JSON is invalid once the element closes
+	parser.Append("<tool>{\"a\":</tool>")
+	nodes, _ = parser.GetXmlNodes()
+	if nodes[2].JSONValid == nil || *nodes[2].JSONValid {
+		t.Errorf("expected JSONValid=false for truncated JSON")
+	}
+
+	// Other elements are not checked
+	parser.Append("<note>{}</note>")
+	nodes, _ = parser.GetXmlNodes()
+	if nodes[3].JSONValid != nil {
+		t.Errorf("expected nil JSONValid for unregistered element")
+	}
+}
+
+// manyChunkTag builds an opening tag with many attributes
+func manyChunkTag(attributes int) string {
+	var tag strings.Builder
+	tag.WriteString("<tool")
+	for i := 0; i < attributes; i++ {
+		fmt.Fprintf(&tag, " attr%d=\"value %d\"", i, i)
+	}
+	tag.WriteString(">")
+	return tag.String()
+}
+
+// TestManyChunkTag tests a tag whose attributes stream in thousands of tiny chunks
+func TestManyChunkTag(t *testing.T) {
+	input := "Before " + manyChunkTag(500) + "content</tool>"
+
+	parser := NewStreamXmlParser()
+	for i := 0; i < len(input); i += 3 {
+		end := min(i+3, len(input))
+		if err := parser.Append(input[i:end]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 1 {
+		t.Fatalf("expected 1 node, got %d", len(nodes))
+	}
+	node := nodes[0]
+	if node.Partial || node.Name != "tool" || node.Content != "content" {
+		t.Errorf("unexpected node %q partial=%v content=%q", node.Name, node.Partial, node.Content)
+	}
+	if len(node.Attributes) != 500 {
+		t.Fatalf("expected 500 attributes, got %d", len(node.Attributes))
+	}
+	for _, i := range []int{0, 250, 499} {
+		key := fmt.Sprintf("attr%d", i)
+		if node.Attributes[key] != fmt.Sprintf("value %d", i) {
+			t.Errorf("expected %s='value %d', got '%s'", key, i, node.Attributes[key])
+		}
+	}
+}
+
+// BenchmarkManyChunkTag measures streaming a large tag in tiny chunks
+func BenchmarkManyChunkTag(b *testing.B) {
+	input := manyChunkTag(2000) + "content</tool>"
+
+	for b.Loop() {
+		parser := NewStreamXmlParser()
+		for i := 0; i < len(input); i += 2 {
+			parser.Append(input[i:min(i+2, len(input))])
+		}
+	}
+}
+
+// TestRawAttributes tests capturing the attribute text exactly as written
+func TestRawAttributes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`<tool  name = "search"   type='x y'>q</tool>`, `  name = "search"   type='x y'`},
+		{`<tool a="1" b=2 />`, ` a="1" b=2 `},
+		{`<tool a='x/y'/>`, ` a='x/y'`},
+		{`<tool>q</tool>`, ``},
+	}
+
+	for _, tt := range tests {
+		parser := NewStreamXmlParser()
+		// Split the input to make sure raw text survives streaming
+		parser.Append(tt.input[:7])
+		parser.Append(tt.input[7:])
+
+		node, _ := parser.GetXmlNode()
+		if node == nil {
+			t.Fatalf("%s: expected a node", tt.input)
+		}
+		if node.RawAttributes != tt.expected {
+			t.Errorf("%s: expected raw attributes %q, got %q", tt.input, tt.expected, node.RawAttributes)
+		}
+	}
+}
+
+// TestDoubledBracketEscape tests "<<" and ">>" as literal brackets, including split escapes
+func TestDoubledBracketEscape(t *testing.T) {
+	config := DefaultConfig()
+	config.DoubledBracketEscape = true
+
+	tests := []struct {
+		name    string
+		chunks  []string
+		text    string
+		content []string
+	}{
+		{"whole", []string{"a << b >> c"}, "a < b > c", nil},
+		{"split", []string{"a <", "< b >", "> c"}, "a < b > c", nil},
+		{"mixed", []string{"x <<y ", "<tool>1 <", "< 2 >> 0</tool> z"}, "x <y  z", []string{"1 < 2 > 0"}},
+		{"tag after escape", []string{"<<<tool>", "v</tool>"}, "<", []string{"v"}},
+		{"split tag start", []string{"a <", "tool>v</tool>"}, "a ", []string{"v"}},
+		{"final bracket", []string{"a <"}, "a <", nil},
+		{"final bracket after escape", []string{"a <<", "<"}, "a <<", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewStreamXmlParserWithConfig(config)
+			for _, chunk := range tt.chunks {
+				parser.Append(chunk)
+			}
+			parser.Finalize()
+
+			text, _ := parser.GetText()
+			if text != tt.text {
+				t.Errorf("expected text %q, got %q", tt.text, text)
+			}
+			nodes, _ := parser.GetXmlNodes()
+			if len(nodes) != len(tt.content) {
+				t.Fatalf("expected %d nodes, got %d", len(tt.content), len(nodes))
+			}
+			for i, content := range tt.content {
+				if nodes[i].Content != content {
+					t.Errorf("node %d: expected content %q, got %q", i, content, nodes[i].Content)
+				}
+			}
+		})
+	}
+}
+
+// TestHasXml tests the fast check for whether any XML was produced
+func TestHasXml(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("Just some ")
+	parser.Append("plain text.")
+	if parser.HasXml() {
+		t.Errorf("expected HasXml=false for text-only stream")
+	}
+
+	parser.Append(" Now <to")
+	if !parser.HasXml() {
+		t.Errorf("expected HasXml=true once a partial node exists")
+	}
+	parser.Append("ol>x</tool>")
+	parser.DrainCompletedNodes()
+	if !parser.HasXml() {
+		t.Errorf("expected HasXml to stay true after draining")
+	}
+
+	// A '<' that turns out to be text is not XML
+	parser = NewStreamXmlParser()
+	parser.Append("if a <")
+	parser.Append(" b")
+	parser.Finalize()
+	if parser.HasXml() {
+		t.Errorf("expected HasXml=false when '<' is text")
+	}
+}
+
+// TestOnAppendTokenBatches tests the per-append token batches for a chunked stream
+func TestOnAppendTokenBatches(t *testing.T) {
+	parser := NewStreamXmlParser()
+	var batches [][]TokenType
+	parser.OnAppend(func(newTokens []Token) {
+		types := make([]TokenType, 0, len(newTokens))
+		for _, token := range newTokens {
+			types = append(types, token.Type)
+		}
+		batches = append(batches, types)
+	})
+
+	parser.Append("Hi <to")
+	parser.Append("ol a=\"1\">")
+	parser.Append("body</tool>")
+
+	expected := [][]TokenType{
+		{TokenText, TokenIncomplete},
+		{TokenOpenBracket, TokenElementName, TokenAttributeName, TokenEquals, TokenAttributeValue, TokenCloseBracket},
+		{TokenText, TokenOpenBracket, TokenSlash, TokenElementName, TokenCloseBracket},
+	}
+	if len(batches) != len(expected) {
+		t.Fatalf("expected %d batches, got %d: %v", len(expected), len(batches), batches)
+	}
+	for i := range expected {
+		if len(batches[i]) != len(expected[i]) {
+			t.Errorf("batch %d: expected %v, got %v", i, expected[i], batches[i])
+			continue
+		}
+		for j := range expected[i] {
+			if batches[i][j] != expected[i][j] {
+				t.Errorf("batch %d token %d: expected %v, got %v", i, j, expected[i][j], batches[i][j])
+			}
+		}
+	}
+}
+
+// TestGreaterThanInElementContent tests that bare '>' characters stay in element content
+func TestGreaterThanInElementContent(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("<expr>a > b >> c ></expr>")
+
+	node, err := parser.GetXmlNode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if node.Content != "a > b >> c >" {
+		t.Errorf("expected content %q, got %q", "a > b >> c >", node.Content)
+	}
+	if node.Partial {
+		t.Errorf("expected complete node")
+	}
+}
+
+// TestLessThanInElementContent tests that bare '<' characters stay in element content
+func TestLessThanInElementContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "comparisons", content: "1 < 2 < 3"},
+		{name: "tag-like comparison", content: "if a<b && c>d"},
+		{name: "loop", content: "for i:=0; i<n; i++ { x<<=1 }"},
+		{name: "quotes and spacing", content: "x <y a='1'  b>z</y> w"},
+		{name: "unclosed tag-like text", content: "1 < 2 <x>3"},
+		{name: "unterminated tag", content: "a<b c"},
+	}
+
+	for _, tt := range tests {
+		input := "<expr>" + tt.content + "</expr>"
+		for _, size := range []int{1, 2, 5, len(input)} {
+			t.Run(fmt.Sprintf("%s/%d", tt.name, size), func(t *testing.T) {
+				parser := NewStreamXmlParser()
+				for i := 0; i < len(input); i += size {
+					parser.Append(input[i:min(i+size, len(input))])
+				}
+
+				nodes, _ := parser.GetXmlNodes()
+				if len(nodes) != 1 {
+					t.Fatalf("expected 1 node, got %d", len(nodes))
+				}
+				if nodes[0].Content != tt.content {
+					t.Errorf("expected content %q, got %q", tt.content, nodes[0].Content)
+				}
+				if nodes[0].Partial {
+					t.Errorf("expected complete node")
+				}
+			})
+		}
+	}
+}
+
+// TestIncompleteTagInContentIsNotDuplicated tests that provisional content for a split nested tag is replaced
+func TestIncompleteTagInContentIsNotDuplicated(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("<outer>text<inn")
+
+	node, _ := parser.GetXmlNode()
+	if node.Content != "text<inn" {
+		t.Errorf("expected provisional content %q, got %q", "text<inn", node.Content)
+	}
+
+	parser.Append("er>x</inner></outer>")
+	node, _ = parser.GetXmlNode()
+	if node.Content != "text<inner>x</inner>" {
+		t.Errorf("expected content %q, got %q", "text<inner>x</inner>", node.Content)
+	}
+}
+
+// TestContentBufferTruncate tests that dropped content is reused instead of copied
+func TestContentBufferTruncate(t *testing.T) {
+	var c contentBuffer
+	c.b.Grow(64)
+	c.WriteString("text<in")
+	before := c.b.String()
+
+	c.truncate(4)
+	if c.String() != "text" || c.Len() != 4 {
+		t.Fatalf("expected %q after truncate, got %q", "text", c.String())
+	}
+	if before != "text<in" {
+		t.Errorf("expected earlier strings to stay intact, got %q", before)
+	}
+
+	// The completed tag extends the dropped bytes, so they are reused in place
+	c.WriteString("<inner>")
+	if c.String() != "text<inner>" || c.b.Len() != len("text<inner>") || unsafe.StringData(c.String()) != unsafe.StringData(before) {
+		t.Errorf("expected the dropped bytes to be reused, got %q", c.String())
+	}
+
+	// A shorter write that the dropped bytes start with is reused too
+	c.truncate(4)
+	c.WriteString("<")
+	if c.String() != "text<" {
+		t.Errorf("expected %q, got %q", "text<", c.String())
+	}
+	c.WriteString("b")
+	if c.String() != "text<b" {
+		t.Errorf("expected %q after a different write, got %q", "text<b", c.String())
+	}
+	if before != "text<in" {
+		t.Errorf("expected earlier strings to stay intact, got %q", before)
+	}
+}
+
+// BenchmarkProvisionalContent measures nested tags split across chunks inside large content
+func BenchmarkProvisionalContent(b *testing.B) {
+	text := strings.Repeat("x", 1<<20)
+	for b.Loop() {
+		parser := NewStreamXmlParser()
+		parser.Append("<outer>" + text)
+		for range 200 {
+			parser.Append("<in")
+			parser.Append("ner")
+			parser.Append(">y</inner>")
+		}
+	}
+}
+
+// TestPushContextLateJoin tests a stream joined mid-content with a pushed context
+func TestPushContextLateJoin(t *testing.T) {
+	parser := NewStreamXmlParser()
+	if err := parser.PushContext("tool"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parser.Append("cont")
+	node, _ := parser.GetXmlNode()
+	if node == nil || node.Name != "tool" || !node.Partial || node.Content != "cont" {
+		t.Fatalf("expected partial tool node with content %q, got %+v", "cont", node)
+	}
+
+	parser.Append("ent</tool> done")
+	node, _ = parser.GetXmlNode()
+	if node.Partial {
+		t.Errorf("expected the closing tag to complete the pushed context")
+	}
+	if node.Content != "content" {
+		t.Errorf("expected content %q, got %q", "content", node.Content)
+	}
+	text, _ := parser.GetText()
+	if text != " done" {
+		t.Errorf("expected text %q, got %q", " done", text)
+	}
+}
+
+// TestPushContextInsideElement tests that a context cannot be pushed inside an element
+func TestPushContextInsideElement(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("<tool>")
+	if err := parser.PushContext("other"); !errors.Is(err, ErrContextNotAtTopLevel) {
+		t.Errorf("expected ErrContextNotAtTopLevel, got %v", err)
+	}
+}
+
+// TestStrayCloseModes tests a dangling closing tag without a pushed context
+func TestStrayCloseModes(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         StrayCloseMode
+		expectedText string
+		warnings     int
+	}{
+		{name: "drop", mode: StrayCloseDrop, expectedText: "content after"},
+		{name: "text", mode: StrayCloseText, expectedText: "content</tool> after"},
+		{name: "warn", mode: StrayCloseWarn, expectedText: "content after", warnings: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.StrayCloseMode = tt.mode
+			parser := NewStreamXmlParserWithConfig(config)
+			parser.Append("content</to")
+			parser.Append("ol> after")
+
+			text, _ := parser.GetText()
+			if text != tt.expectedText {
+				t.Errorf("expected text %q, got %q", tt.expectedText, text)
+			}
+			if parser.HasXml() {
+				t.Errorf("expected no XML nodes")
+			}
+			warnings := parser.Warnings()
+			if len(warnings) != tt.warnings {
+				t.Fatalf("expected %d warnings, got %d", tt.warnings, len(warnings))
+			}
+			if tt.warnings > 0 && (!errors.Is(warnings[0], ErrStrayClosingTag) || warnings[0].Detail != "tool") {
+				t.Errorf("unexpected warning %v", warnings[0])
+			}
+		})
+	}
+}
+
+// TestApproxMemoryUsage tests that the estimate grows with input and shrinks after draining and compaction
+func TestApproxMemoryUsage(t *testing.T) {
+	config := DefaultConfig()
+	config.BufferCleanupThreshold = 64
+	parser := NewStreamXmlParserWithConfig(config)
+
+	empty := parser.ApproxMemoryUsage()
+	parser.Append("<tool name=\"a\">")
+	parser.Append(strings.Repeat("x", 200))
+	inElement := parser.ApproxMemoryUsage()
+	if inElement <= empty {
+		t.Errorf("expected usage to grow from %d, got %d", empty, inElement)
+	}
+
+	for i := 0; i < 20; i++ {
+		parser.Append(fmt.Sprintf("</tool><tool name=\"%d\">body", i))
+	}
+	parser.Append("</tool>")
+	full := parser.ApproxMemoryUsage()
+	if full <= inElement {
+		t.Errorf("expected usage to grow from %d, got %d", inElement, full)
+	}
+
+	parser.DrainCompletedNodes()
+	drained := parser.ApproxMemoryUsage()
+	if drained >= full {
+		t.Errorf("expected usage to shrink after draining from %d, got %d", full, drained)
+	}
+	if buffer := len(parser.tokenizer.GetBuffer()); buffer >= 64 {
+		t.Errorf("expected the buffer to be compacted below the threshold, got %d bytes", buffer)
+	}
+}
+
+// TestSetDecodedAttributes tests decoding entities only in the listed attributes
+func TestSetDecodedAttributes(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.SetDecodedAttributes("tool", []string{"json"})
+	parser.Append(`<tool id="a&amp;b" json="{&quot;q&quot;:&quot;R&amp;D&quot;}">x</tool>`)
+	parser.Append(`<other json="&quot;raw&quot;"/>`)
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	if got := nodes[0].Attributes["json"]; got != `{"q":"R&D"}` {
+		t.Errorf("expected decoded json attribute, got %q", got)
+	}
+	if got := nodes[0].OrderedAttributes[1].Value; got != `{"q":"R&D"}` {
+		t.Errorf("expected decoded ordered attribute, got %q", got)
+	}
+	if got := nodes[0].Attributes["id"]; got != "a&amp;b" {
+		t.Errorf("expected raw id attribute, got %q", got)
+	}
+	if got := nodes[1].Attributes["json"]; got != "&quot;raw&quot;" {
+		t.Errorf("expected raw attribute on other element, got %q", got)
+	}
+
+	parser.SetDecodedAttributes("tool", nil)
+	parser.Append(`<tool json="&lt;"/>`)
+	nodes, _ = parser.GetXmlNodes()
+	if got := nodes[2].Attributes["json"]; got != "&lt;" {
+		t.Errorf("expected raw attribute after removing the setting, got %q", got)
+	}
+}
+
+// TestSetTextMode tests toggling text mode around a block containing tag-like text
+func TestSetTextMode(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("Example:\n")
+	parser.SetTextMode(true)
+	parser.Append("<tool name=\"x\">not")
+	parser.Append(" a call</tool> <br/> <<\n")
+	parser.SetTextMode(false)
+	parser.Append("<tool>real</tool>")
+
+	text, _ := parser.GetText()
+	expected := "Example:\n<tool name=\"x\">not a call</tool> <br/> <<\n"
+	if text != expected {
+		t.Errorf("expected text %q, got %q", expected, text)
+	}
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 1 || nodes[0].Name != "tool" || nodes[0].Content != "real" {
+		t.Errorf("expected only the tool node after text mode, got %+v", nodes)
+	}
+}
+
+// TestSetTextModeWithIncompleteTag tests that a pending incomplete tag becomes text
+func TestSetTextModeWithIncompleteTag(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("see <code")
+	if !parser.HasXml() {
+		t.Fatalf("expected a partial node for the incomplete tag")
+	}
+
+	parser.SetTextMode(true)
+	parser.Append(">x</code>")
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 0 {
+		t.Errorf("expected no XML nodes, got %d", len(nodes))
+	}
+	text, _ := parser.GetText()
+	if text != "see <code>x</code>" {
+		t.Errorf("expected text %q, got %q", "see <code>x</code>", text)
+	}
+}
+
+// TestRespectCodeFences tests that tags inside fenced code stay literal while tags outside are parsed
+func TestRespectCodeFences(t *testing.T) {
+	input := "Use it like this:\n```xml\n<tool name=\"x\">example</tool>\n```\n<tool name=\"y\">real</tool>\n  ````\n<a>``` still code</a>\n````\ndone"
+	expectedText := "Use it like this:\n```xml\n<tool name=\"x\">example</tool>\n```\n\n  ````\n<a>``` still code</a>\n````\ndone"
+
+	for _, size := range []int{len(input), 5, 2, 1} {
+		config := DefaultConfig()
+		config.RespectCodeFences = true
+		parser := NewStreamXmlParserWithConfig(config)
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+
+		text, _ := parser.GetText()
+		if text != expectedText {
+			t.Errorf("chunk size %d: expected text %q, got %q", size, expectedText, text)
+		}
+		nodes, _ := parser.GetXmlNodes()
+		if len(nodes) != 1 || nodes[0].Attributes["name"] != "y" || nodes[0].Content != "real" {
+			t.Errorf("chunk size %d: expected only the tool node outside the fence, got %+v", size, nodes)
+		}
+	}
+}
+
+// TestRespectCodeFencesOnlyAtTopLevel tests that fences inside element content do not change parsing
+func TestRespectCodeFencesOnlyAtTopLevel(t *testing.T) {
+	config := DefaultConfig()
+	config.RespectCodeFences = true
+	parser := NewStreamXmlParserWithConfig(config)
+	parser.Append("<answer>\n```\ncode\n</answer>\n<tool>x</tool>")
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	if nodes[0].Content != "\n```\ncode\n" {
+		t.Errorf("expected content %q, got %q", "\n```\ncode\n", nodes[0].Content)
+	}
+	if nodes[1].Name != "tool" || nodes[1].Partial {
+		t.Errorf("expected a complete tool node, got %+v", nodes[1])
+	}
+}
+
+// TestCodeFencesDisabledByDefault tests that fences are ordinary text without RespectCodeFences
+func TestCodeFencesDisabledByDefault(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("```\n<tool>x</tool>\n```")
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 1 {
+		t.Errorf("expected the tool node to be parsed, got %d nodes", len(nodes))
+	}
+}
+
+// TestCurrentElement tests the element receiving content while streaming
+func TestCurrentElement(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("intro ")
+	if current := parser.CurrentElement(); current != nil {
+		t.Errorf("expected nil at the top level, got %+v", current)
+	}
+
+	parser.Append("<tool name=\"a\">par")
+	current := parser.CurrentElement()
+	if current == nil || current.Name != "tool" || current.Content != "par" {
+		t.Fatalf("expected the open tool element with content %q, got %+v", "par", current)
+	}
+
+	current.Attributes["name"] = "changed"
+	if node, _ := parser.GetXmlNode(); node.Attributes["name"] != "a" {
+		t.Errorf("expected CurrentElement to return a copy")
+	}
+
+	parser.Append("tial <b>nested</b>")
+	if current := parser.CurrentElement(); current == nil || current.Content != "partial <b>nested</b>" {
+		t.Errorf("expected the tool element during nested content, got %+v", current)
+	}
+
+	parser.Append("</tool>")
+	if current := parser.CurrentElement(); current != nil {
+		t.Errorf("expected nil after close, got %+v", current)
+	}
+}
+
+// TestSetTextElement tests prose and a nested tool call inside the text element
+func TestSetTextElement(t *testing.T) {
+	input := "<response>Let me check.\n<tool name=\"search\">q</tool>\nFound it.</response>\n<tool name=\"after\"/>"
+
+	for _, size := range []int{len(input), 3, 1} {
+		parser := NewStreamXmlParser()
+		parser.SetTextElement("response")
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+
+		text, _ := parser.GetText()
+		if text != "Let me check.\n\nFound it.\n" {
+			t.Errorf("chunk size %d: expected text %q, got %q", size, "Let me check.\n\nFound it.\n", text)
+		}
+		nodes, _ := parser.GetXmlNodes()
+		if len(nodes) != 2 {
+			t.Fatalf("chunk size %d: expected 2 nodes, got %d", size, len(nodes))
+		}
+		if nodes[0].Attributes["name"] != "search" || nodes[0].Content != "q" || nodes[0].Partial {
+			t.Errorf("chunk size %d: unexpected nested tool node %+v", size, nodes[0])
+		}
+		if nodes[1].Attributes["name"] != "after" {
+			t.Errorf("chunk size %d: unexpected sibling node %+v", size, nodes[1])
+		}
+		if nodes[0].Ordinal != 0 || nodes[1].Ordinal != 1 {
+			t.Errorf("chunk size %d: expected ordinals 0 and 1, got %d and %d", size, nodes[0].Ordinal, nodes[1].Ordinal)
+		}
+	}
+}
+
+// TestMixedQuoteAttributesSplitAtEveryPoint tests quotes of the other type inside values for every chunk split
+func TestMixedQuoteAttributesSplitAtEveryPoint(t *testing.T) {
+	input := `<tool a='x"y' b="p'q" c='>"<' d="'>'">body</tool>`
+	expected := []Attribute{
+		{Name: "a", Value: `x"y`},
+		{Name: "b", Value: `p'q`},
+		{Name: "c", Value: `>"<`},
+		{Name: "d", Value: `'>'`},
+	}
+
+	for split := 1; split < len(input); split++ {
+		for _, second := range []int{split, min(split+3, len(input))} {
+			parser := NewStreamXmlParser()
+			parser.Append(input[:split])
+			parser.Append(input[split:second])
+			parser.Append(input[second:])
+
+			nodes, _ := parser.GetXmlNodes()
+			if len(nodes) != 1 {
+				t.Fatalf("split %d/%d: expected 1 node, got %d", split, second, len(nodes))
+			}
+			node := nodes[0]
+			if node.Content != "body" || node.Partial {
+				t.Errorf("split %d/%d: expected complete node with content %q, got %q", split, second, "body", node.Content)
+			}
+			if len(node.OrderedAttributes) != len(expected) {
+				t.Fatalf("split %d/%d: expected %d attributes, got %v", split, second, len(expected), node.OrderedAttributes)
+			}
+			for i, attr := range expected {
+				if node.OrderedAttributes[i] != attr {
+					t.Errorf("split %d/%d: expected attribute %v, got %v", split, second, attr, node.OrderedAttributes[i])
+				}
+			}
+		}
+	}
+}
+
+// TestPauseResume tests that appended data is held while paused and processed on resume
+func TestPauseResume(t *testing.T) {
+	parser := NewStreamXmlParser()
+	sink := &recordingSink{}
+	parser.AddSink(sink)
+	parser.Append("before <a>1</a>")
+
+	parser.Pause()
+	parser.Append(" middle <b>2</b>")
+	parser.Append("<c>3</c>")
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 1 {
+		t.Errorf("expected 1 node while paused, got %d", len(nodes))
+	}
+	text, _ := parser.GetText()
+	if text != "before " {
+		t.Errorf("expected text %q while paused, got %q", "before ", text)
+	}
+	eventsWhilePaused := len(sink.events)
+
+	if err := parser.Resume(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nodes, _ = parser.GetXmlNodes()
+	if len(nodes) != 3 || nodes[1].Name != "b" || nodes[2].Name != "c" {
+		t.Errorf("expected all 3 nodes after resume, got %d", len(nodes))
+	}
+	text, _ = parser.GetText()
+	if text != "before  middle " {
+		t.Errorf("expected text %q after resume, got %q", "before  middle ", text)
+	}
+	if len(sink.events) <= eventsWhilePaused {
+		t.Errorf("expected sink events after resume")
+	}
+
+	parser.Append("<d/>")
+	if nodes, _ = parser.GetXmlNodes(); len(nodes) != 4 {
+		t.Errorf("expected appends to be processed after resume, got %d nodes", len(nodes))
+	}
+}
+
+// TestPrecedingText tests capturing the prose directly before each node
+func TestPrecedingText(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("<first/>I will ")
+	parser.Append("search now.\n<to")
+	parser.Append("ol>q</tool><next/>")
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 3 {
+		t.Fatalf("expected 3 nodes, got %d", len(nodes))
+	}
+	if nodes[0].PrecedingText != "" {
+		t.Errorf("expected empty preceding text for the first node, got %q", nodes[0].PrecedingText)
+	}
+	if nodes[1].PrecedingText != "I will search now.\n" {
+		t.Errorf("expected preceding text %q, got %q", "I will search now.\n", nodes[1].PrecedingText)
+	}
+	if nodes[2].PrecedingText != "" {
+		t.Errorf("expected empty preceding text for adjacent nodes, got %q", nodes[2].PrecedingText)
+	}
+}
+
+// TestNormalizeEmptyElements tests that self-closing and empty-body elements have the same shape
+func TestNormalizeEmptyElements(t *testing.T) {
+	config := DefaultConfig()
+	config.NormalizeEmptyElements = true
+	parser := NewStreamXmlParserWithConfig(config)
+	parser.Append(`<tool a="1"/>`)
+	parser.Append(`<to`)
+	parser.Append(`ol a="1"></tool>`)
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	for i, node := range nodes {
+		if node.Name != "tool" || node.SelfClosing || node.Partial || node.Content != "" || node.Attributes["a"] != "1" {
+			t.Errorf("node %d: unexpected shape %+v", i, node)
+		}
+		if out := node.Marshal(); out != `<tool a="1"></tool>` {
+			t.Errorf("node %d: expected %q, got %q", i, `<tool a="1"></tool>`, out)
+		}
+	}
+
+	parser = NewStreamXmlParser()
+	parser.Append(`<tool/>`)
+	if node, _ := parser.GetXmlNode(); !node.SelfClosing {
+		t.Errorf("expected SelfClosing without NormalizeEmptyElements")
+	}
+}
+
+// TestMaxStoredAttributes tests storing only the first attributes of a tag past the cap
+func TestMaxStoredAttributes(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxStoredAttributes = 2
+	parser := NewStreamXmlParserWithConfig(config)
+	parser.Append(`<tool a="1" b="2" c="3" d="4">body</tool><small x="1"/>`)
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	tool := nodes[0]
+	if len(tool.Attributes) != 2 || tool.Attributes["a"] != "1" || tool.Attributes["b"] != "2" {
+		t.Errorf("expected only a and b to be stored, got %v", tool.Attributes)
+	}
+	if len(tool.OrderedAttributes) != 2 {
+		t.Errorf("expected 2 ordered attributes, got %v", tool.OrderedAttributes)
+	}
+	if !tool.AttributesTruncated {
+		t.Errorf("expected AttributesTruncated to be set")
+	}
+	if tool.Content != "body" || tool.Partial {
+		t.Errorf("expected the rest of the element to parse normally, got %+v", tool)
+	}
+	if nodes[1].AttributesTruncated {
+		t.Errorf("expected AttributesTruncated to be false under the cap")
+	}
+
+	config.MaxStoredAttributes = -1
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfiguration) {
+		t.Errorf("expected negative MaxStoredAttributes to be invalid, got %v", err)
+	}
+}
+
+// TestTrimAttributeValues tests whitespace around quoted attribute values under both settings
+func TestTrimAttributeValues(t *testing.T) {
+	input := "<tool name=\" search \" mode='\tfast\n' id=7 blank=\"  \">body</tool>"
+
+	tests := []struct {
+		trim     bool
+		expected map[string]string
+	}{
+		{false, map[string]string{"name": " search ", "mode": "\tfast\n", "id": "7", "blank": "  "}},
+		{true, map[string]string{"name": "search", "mode": "fast", "id": "7", "blank": ""}},
+	}
+
+	for _, tt := range tests {
+		config := DefaultConfig()
+		config.TrimAttributeValues = tt.trim
+		parser := NewStreamXmlParserWithConfig(config)
+		for i := 0; i < len(input); i += 5 {
+			parser.Append(input[i:min(i+5, len(input))])
+		}
+
+		node, _ := parser.GetXmlNode()
+		if node == nil {
+			t.Fatalf("trim=%v: expected a node", tt.trim)
+		}
+		for key, value := range tt.expected {
+			if node.Attributes[key] != value {
+				t.Errorf("trim=%v: expected %s=%q, got %q", tt.trim, key, value, node.Attributes[key])
+			}
+		}
+		if values := node.AttributeValues("name"); len(values) != 1 || values[0] != tt.expected["name"] {
+			t.Errorf("trim=%v: expected ordered value %q, got %q", tt.trim, tt.expected["name"], values)
+		}
+	}
+}
+
+// TestReparse tests re-parsing buffered input under different allowed elements
+func TestReparse(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("Hi <tool>a</tool> <note>b</note>")
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+
+	config := DefaultConfig()
+	config.AllowedElements = []string{"tool"}
+	reparsed, err := parser.Reparse(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nodes, _ = reparsed.GetXmlNodes()
+	if len(nodes) != 1 || nodes[0].Name != "tool" {
+		t.Errorf("expected only the tool node after reparse, got %d nodes", len(nodes))
+	}
+	text, _ := reparsed.GetText()
+	if text != "Hi  <note>b</note>" {
+		t.Errorf("expected text %q, got %q", "Hi  <note>b</note>", text)
+	}
+
+	// The reparsed parser keeps streaming
+	reparsed.Append("<tool>c</tool>")
+	if nodes, _ = reparsed.GetXmlNodes(); len(nodes) != 2 {
+		t.Errorf("expected 2 nodes after appending, got %d", len(nodes))
+	}
+}
+
+// TestReparseAfterBufferCleanup tests that Reparse needs RetainInput once input was discarded
+func TestReparseAfterBufferCleanup(t *testing.T) {
+	input := strings.Repeat("<tool>x</tool>", 200)
+
+	parser := NewStreamXmlParser()
+	parser.Append(input)
+	if _, err := parser.Reparse(DefaultConfig()); !errors.Is(err, ErrInputNotRetained) {
+		t.Errorf("expected ErrInputNotRetained, got %v", err)
+	}
+
+	config := DefaultConfig()
+	config.RetainInput = true
+	parser = NewStreamXmlParserWithConfig(config)
+	parser.Append(input)
+	reparsed, err := parser.Reparse(DefaultConfig())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nodes, _ := reparsed.GetXmlNodes(); len(nodes) != 200 {
+		t.Errorf("expected 200 nodes, got %d", len(nodes))
+	}
+
+	if _, err := parser.Reparse(ParserConfig{}); !errors.Is(err, ErrInvalidConfiguration) {
+		t.Errorf("expected ErrInvalidConfiguration, got %v", err)
+	}
+}
+
+// TestWhitespaceNodes tests distinguishing inter-element whitespace from meaningful text in the AST
+func TestWhitespaceNodes(t *testing.T) {
+	input := "<a/>\n  <b/>\n\nSome text\n<c/>\t \n"
+
+	for _, size := range []int{len(input), 1} {
+		config := DefaultConfig()
+		config.WhitespaceNodes = true
+		parser := NewStreamXmlParserWithConfig(config)
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+
+		var shape []string
+		for _, node := range parser.GetAST() {
+			switch node.Type {
+			case ASTNodeXml:
+				shape = append(shape, "xml:"+node.XmlNode.Name)
+			case ASTNodeWhitespace:
+				if n := len(shape); n > 0 && strings.HasPrefix(shape[n-1], "ws:") {
+					shape[n-1] += node.Text
+				} else {
+					shape = append(shape, "ws:"+node.Text)
+				}
+			case ASTNodeText:
+				if n := len(shape); n > 0 && strings.HasPrefix(shape[n-1], "text:") {
+					shape[n-1] += node.Text
+				} else {
+					shape = append(shape, "text:"+node.Text)
+				}
+			}
+		}
+
+		expected := []string{"xml:a", "ws:\n  ", "xml:b", "text:\n\nSome text\n", "xml:c", "ws:\t \n"}
+		if strings.Join(shape, "|") != strings.Join(expected, "|") {
+			t.Errorf("chunk size %d: expected %q, got %q", size, expected, shape)
+		}
+
+		text, _ := parser.GetText()
+		if text != "\n  \n\nSome text\n\t \n" {
+			t.Errorf("chunk size %d: expected GetText to include whitespace, got %q", size, text)
+		}
+	}
+}
+
+// TestDottedElementNames tests versioned element names containing dots
+func TestDottedElementNames(t *testing.T) {
+	config := DefaultConfig()
+	config.AllowedElements = []string{"tool.v2", "ping.v1"}
+	parser := NewStreamXmlParserWithConfig(config)
+	parser.Append(`<tool.v2 name="x">args <tool.v1>no</tool.v1></tool.v2>`)
+	parser.Append(`<pi`)
+	parser.Append(`ng.v1 ok="1"/>`)
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	if nodes[0].Name != "tool.v2" || nodes[0].Partial || nodes[0].Content != "args <tool.v1>no</tool.v1>" {
+		t.Errorf("unexpected dotted element %+v", nodes[0])
+	}
+	if nodes[1].Name != "ping.v1" || !nodes[1].SelfClosing || nodes[1].Attributes["ok"] != "1" {
+		t.Errorf("unexpected dotted self-closing element %+v", nodes[1])
+	}
+}
+
+// TestGetTextEscaped tests HTML escaping of text with tag-like content
+func TestGetTextEscaped(t *testing.T) {
+	config := DefaultConfig()
+	config.AllowedElements = []string{"tool"}
+	parser := NewStreamXmlParserWithConfig(config)
+	parser.Append("if a < b && c > d ")
+	parser.Append("<script>x</script> &amp; <tool>q</tool>")
+
+	expected := "if a &lt; b &amp;&amp; c &gt; d &lt;script&gt;x&lt;/script&gt; &amp;amp; "
+	if got := parser.GetTextEscaped(); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if text, _ := parser.GetText(); text != "if a < b && c > d <script>x</script> &amp; " {
+		t.Errorf("expected GetText to stay unescaped, got %q", text)
+	}
+}
+
+// TestPreamble tests capturing the text before the first XML node
+func TestPreamble(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("I will look ")
+	if preamble := parser.Preamble(); preamble != "I will look " {
+		t.Errorf("expected preamble %q while streaming, got %q", "I will look ", preamble)
+	}
+
+	parser.Append("that up.\n<to")
+	parser.Append("ol>q</tool>\nDone. <more/> end")
+	if preamble := parser.Preamble(); preamble != "I will look that up.\n" {
+		t.Errorf("expected preamble %q, got %q", "I will look that up.\n", preamble)
+	}
+	if text, _ := parser.GetText(); text != "I will look that up.\n\nDone.  end" {
+		t.Errorf("expected full text %q, got %q", "I will look that up.\n\nDone.  end", text)
+	}
+
+	parser = NewStreamXmlParser()
+	parser.Append("<tool/>text")
+	if preamble := parser.Preamble(); preamble != "" {
+		t.Errorf("expected empty preamble, got %q", preamble)
+	}
+
+	// ClearText releases the preamble of a text-only stream
+	parser = NewStreamXmlParser()
+	chunk := strings.Repeat("x", 1000)
+	for range 100 {
+		parser.Append(chunk)
+		parser.ClearText()
+	}
+	parser.Append("tail ")
+	if preamble := parser.Preamble(); preamble != "tail " {
+		t.Errorf("expected only text since ClearText in the preamble, got %d bytes", len(preamble))
+	}
+	if size := parser.preamble.Cap(); size > len(chunk) {
+		t.Errorf("expected the cleared preamble to be released, still holding %d bytes", size)
+	}
+}
+
+// TestSetElementMaxDepth tests different depth limits for two elements
+func TestSetElementMaxDepth(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxDepth = 3
+	parser := NewStreamXmlParserWithConfig(config)
+	parser.SetElementMaxDepth("thinking", 6)
+	parser.SetElementMaxDepth("tool", 2)
+
+	if err := parser.Append("<thinking><a><b><c><d>deep</d></c></b></a></thinking>"); err != nil {
+		t.Errorf("expected deep nesting in thinking to be allowed, got %v", err)
+	}
+	if err := parser.Append("<tool><arg>x</arg></tool>"); err != nil {
+		t.Errorf("expected one level in tool to be allowed, got %v", err)
+	}
+
+	err := parser.Append("<tool><arg><nested>")
+	if !errors.Is(err, ErrMaxDepthExceeded) {
+		t.Fatalf("expected ErrMaxDepthExceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "<tool>") {
+		t.Errorf("expected the error to name the element, got %q", err.Error())
+	}
+
+	// Other elements keep the global limit
+	parser = NewStreamXmlParserWithConfig(config)
+	parser.SetElementMaxDepth("thinking", 6)
+	if err := parser.Append("<other><a><b><c>"); err != ErrMaxDepthExceeded {
+		t.Errorf("expected the global ErrMaxDepthExceeded, got %v", err)
+	}
+}
+
+// TestOnTextChunk tests that text chunk callbacks add up to GetText
+func TestOnTextChunk(t *testing.T) {
+	input := "Hello there, <tool>args</tool> and bye <b>x</b>!"
+
+	parser := NewStreamXmlParser()
+	var chunks []string
+	parser.OnTextChunk(func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	for i := 0; i < len(input); i += 4 {
+		parser.Append(input[i:min(i+4, len(input))])
+	}
+
+	text, _ := parser.GetText()
+	if joined := strings.Join(chunks, ""); joined != text {
+		t.Errorf("expected chunks to add up to %q, got %q", text, joined)
+	}
+	if len(chunks) < 2 {
+		t.Errorf("expected text to arrive in several chunks, got %d", len(chunks))
+	}
+
+	config := DefaultConfig()
+	config.ContentTextChunks = true
+	parser = NewStreamXmlParserWithConfig(config)
+	var all strings.Builder
+	parser.OnTextChunk(func(chunk string) {
+		all.WriteString(chunk)
+	})
+	parser.Append(input)
+	if all.String() != "Hello there, args and bye x!" {
+		t.Errorf("expected chunks including content, got %q", all.String())
+	}
+}
+
+// TestSetAllowedElementsBetweenChunks tests changing the allowed set between chunks of a single tag
+func TestSetAllowedElementsBetweenChunks(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("a <to")
+	if !parser.HasXml() {
+		t.Fatalf("expected a partial node for the incomplete tag")
+	}
+	parser.SetAllowedElements([]string{"other"})
+	parser.Append("ol>x</tool> <oth")
+	parser.Append("er>y</other>")
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 1 || nodes[0].Name != "other" || nodes[0].Content != "y" {
+		t.Errorf("expected only the other node, got %+v", nodes)
+	}
+	if text, _ := parser.GetText(); text != "a <tool>x</tool> " {
+		t.Errorf("expected the no longer allowed tag as text, got %q", text)
+	}
+	if nodes[0].Ordinal != 0 {
+		t.Errorf("expected the discarded partial node not to use an ordinal, got %d", nodes[0].Ordinal)
+	}
+
+	parser = NewStreamXmlParserWithConfig(ParserConfig{
+		MaxDepth:        100,
+		MaxBufferSize:   1024 * 1024,
+		AllowedElements: []string{"tool"},
+	})
+	parser.Append("<oth")
+	parser.SetAllowedElements(nil)
+	parser.Append("er>z</other>")
+	if node, _ := parser.GetXmlNode(); node == nil || node.Name != "other" || node.Partial {
+		t.Errorf("expected a newly allowed element, got %+v", node)
+	}
+}
+
+// TestSetAllowedElementsWhileOpen tests that open elements still close after they are disallowed
+func TestSetAllowedElementsWhileOpen(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("<tool><arg>1")
+	parser.SetAllowedElements([]string{"other"})
+	parser.Append("</arg><arg>2</arg></tool> <tool>t</tool>")
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 1 {
+		t.Fatalf("expected 1 node, got %d", len(nodes))
+	}
+	if nodes[0].Partial || nodes[0].Content != "<arg>1</arg><arg>2</arg>" {
+		t.Errorf("expected the open tool to close normally, got %+v", nodes[0])
+	}
+	if text, _ := parser.GetText(); text != " <tool>t</tool>" {
+		t.Errorf("expected later tool tags as text, got %q", text)
+	}
+}
+
+// TestNewCompletedNodes tests that each completed node is polled exactly once
+func TestNewCompletedNodes(t *testing.T) {
+	parser := NewStreamXmlParser()
+	poll := func() string {
+		var names []string
+		for _, node := range parser.NewCompletedNodes() {
+			names = append(names, node.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if got := poll(); got != "" {
+		t.Errorf("expected nothing before input, got %q", got)
+	}
+	parser.Append("text <a>1</a> <b>2")
+	if got := poll(); got != "a" {
+		t.Errorf("expected a, got %q", got)
+	}
+	if got := poll(); got != "" {
+		t.Errorf("expected nothing on a repeated poll, got %q", got)
+	}
+	parser.Append("</b> <c/><d")
+	if got := poll(); got != "b,c" {
+		t.Errorf("expected b,c, got %q", got)
+	}
+	parser.Append("/> <e>5</e>")
+	parser.DrainCompletedNodes()
+	parser.Append("<f/>")
+	if got := poll(); got != "f" {
+		t.Errorf("expected only f after draining, got %q", got)
+	}
+	if got := poll(); got != "" {
+		t.Errorf("expected nothing at the end, got %q", got)
+	}
+}
+
+// TestOpenContentLen tests that the open content length tracks growth and resets on close
+func TestOpenContentLen(t *testing.T) {
+	parser := NewStreamXmlParser()
+
+	steps := []struct {
+		chunk    string
+		expected int
+	}{
+		{"text ", 0},
+		{"<tool>", 0},
+		{"abc", 3},
+		{"<arg>d</arg>", 15},
+		{"ef", 17},
+		{"</tool>", 0},
+		{"<next>x", 1},
+	}
+	for _, step := range steps {
+		parser.Append(step.chunk)
+		if got := parser.OpenContentLen(); got != step.expected {
+			t.Errorf("after %q: expected %d, got %d", step.chunk, step.expected, got)
+		}
+	}
+}
+
+// TestSetRequiredAttributes tests present and missing required attributes
+func TestSetRequiredAttributes(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.SetRequiredAttributes("tool", []string{"name"})
+
+	if err := parser.Append(`<tool name="search">q</tool><other/>`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parser.Append("<tool id=")
+	err := parser.Append(`"1">q</tool>`)
+	if !errors.Is(err, ErrMissingRequiredAttribute) {
+		t.Fatalf("expected ErrMissingRequiredAttribute, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "<tool>") || !strings.Contains(err.Error(), `"name"`) {
+		t.Errorf("expected error to name element and attribute, got %q", err)
+	}
+
+	err = parser.Append("<tool/>")
+	if !errors.Is(err, ErrMissingRequiredAttribute) {
+		t.Errorf("expected ErrMissingRequiredAttribute for self-closing tag, got %v", err)
+	}
+
+	// Parsing continues normally after the error
+	if err := parser.Append(`<tool name="x"/>`); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 5 {
+		t.Fatalf("expected 5 nodes, got %d", len(nodes))
+	}
+	for _, node := range nodes {
+		if node.Partial {
+			t.Errorf("expected all nodes complete, got partial <%s>", node.Name)
+		}
+	}
+
+	parser.SetRequiredAttributes("tool", nil)
+	if err := parser.Append("<tool/>"); err != nil {
+		t.Errorf("expected no error after removing the requirement, got %v", err)
+	}
+}
+
+// TestToolCalls tests splitting back-to-back tool calls with and without whitespace between them
+func TestToolCalls(t *testing.T) {
+	inputs := []string{
+		`<tool>{"a":1}</tool><tool>{"b":2}</tool><tool>{"c":3}</tool>`,
+		"<tool>{\"a\":1}</tool>\n<tool>{\"b\":2}</tool>  <note/>\n\t<tool>{\"c\":3}</tool>\n",
+	}
+	expected := []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}
+
+	for _, input := range inputs {
+		for _, size := range []int{len(input), 3, 1} {
+			parser := NewStreamXmlParser()
+			for i := 0; i < len(input); i += size {
+				parser.Append(input[i:min(i+size, len(input))])
+			}
+
+			calls := parser.ToolCalls("tool")
+			if len(calls) != len(expected) {
+				t.Fatalf("%q (chunk size %d): expected %d calls, got %d", input, size, len(expected), len(calls))
+			}
+			for i, call := range calls {
+				if call.Content != expected[i] {
+					t.Errorf("%q (chunk size %d): call %d: expected %q, got %q", input, size, i, expected[i], call.Content)
+				}
+			}
+		}
+	}
+
+	// A call that is still streaming is not returned
+	parser := NewStreamXmlParser()
+	parser.Append(`<tool>{"a":1}</tool><tool>{"b"`)
+	if calls := parser.ToolCalls("tool"); len(calls) != 1 {
+		t.Errorf("expected 1 complete call, got %d", len(calls))
+	}
+	if calls := parser.ToolCalls("missing"); calls == nil || len(calls) != 0 {
+		t.Errorf("expected an empty slice, got %v", calls)
+	}
+}
+
+// TestAppendSpans tests the Append call spans recorded for nodes in known chunk patterns
+func TestAppendSpans(t *testing.T) {
+	config := DefaultConfig()
+	config.TrackAppendSpans = true
+	parser := NewStreamXmlParserWithConfig(config)
+
+	chunks := []string{
+		"text <a>1</a> <b", // 0
+		">2",               // 1
+		"3",                // 2
+		"</b> <c/><d>4</",  // 3
+		"d><e",             // 4
+		"/>tail <f>",       // 5
+	}
+	for _, chunk := range chunks {
+		parser.Append(chunk)
+	}
+
+	expected := map[string][2]int{
+		"a": {0, 0},
+		"b": {0, 3},
+		"c": {3, 3},
+		"d": {3, 4},
+		"e": {4, 5},
+		"f": {5, 5},
+	}
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != len(expected) {
+		t.Fatalf("expected %d nodes, got %d", len(expected), len(nodes))
+	}
+	for _, node := range nodes {
+		if node.AppendSpan != expected[node.Name] {
+			t.Errorf("<%s>: expected span %v, got %v", node.Name, expected[node.Name], node.AppendSpan)
+		}
+	}
+
+	// The open node keeps extending
+	parser.Append("more")
+	if span := parser.LiveNodes()[5].AppendSpan; span != [2]int{5, 6} {
+		t.Errorf("<f>: expected span [5 6], got %v", span)
+	}
+
+	// Spans are not tracked without the flag
+	parser = NewStreamXmlParser()
+	parser.Append("<a>1")
+	parser.Append("</a>")
+	if node, _ := parser.GetXmlNode(); node.AppendSpan != [2]int{} {
+		t.Errorf("expected no span without TrackAppendSpans, got %v", node.AppendSpan)
+	}
+}
+
+// TestDirectiveElements tests recognizing directive elements with and without stripping the sigil
+func TestDirectiveElements(t *testing.T) {
+	input := `<@set key="x" value="y"/> text <tool a="1">q</tool><@if cond="z">body</@if>`
+
+	for _, strip := range []bool{false, true} {
+		config := DefaultConfig()
+		config.StripDirectiveSigil = strip
+		parser := NewStreamXmlParserWithConfig(config)
+		for i := 0; i < len(input); i += 3 {
+			parser.Append(input[i:min(i+3, len(input))])
+		}
+
+		nodes, _ := parser.GetXmlNodes()
+		if len(nodes) != 3 {
+			t.Fatalf("strip=%v: expected 3 nodes, got %d", strip, len(nodes))
+		}
+		sigil := "@"
+		if strip {
+			sigil = ""
+		}
+		expected := []struct {
+			name      string
+			directive bool
+		}{
+			{sigil + "set", true},
+			{"tool", false},
+			{sigil + "if", true},
+		}
+		for i, node := range nodes {
+			if node.Name != expected[i].name || node.Directive != expected[i].directive {
+				t.Errorf("strip=%v: node %d: expected %s (directive %v), got %s (directive %v)",
+					strip, i, expected[i].name, expected[i].directive, node.Name, node.Directive)
+			}
+			if node.Partial {
+				t.Errorf("strip=%v: expected node %d to be complete", strip, i)
+			}
+		}
+		if nodes[0].Attributes["key"] != "x" || nodes[0].Attributes["value"] != "y" {
+			t.Errorf("strip=%v: unexpected directive attributes %v", strip, nodes[0].Attributes)
+		}
+		if nodes[2].Content != "body" {
+			t.Errorf("strip=%v: expected content 'body', got %q", strip, nodes[2].Content)
+		}
+	}
+}
+
+// FuzzAppend tests that chunked input never panics and the parser stays usable
+func FuzzAppend(f *testing.F) {
+	seeds := []string{
+		"Text <tool name=\"x\">content</tool> more",
+		"<a><b><c/></b></a>",
+		"<",
+		"</",
+		"<>",
+		"</>",
+		"< >",
+		"<a",
+		"<a b",
+		"<a b=",
+		"<a b=\"",
+		"<a b='c>d'>e</a>",
+		"<a b=c=d/>",
+		"<a/ >",
+		"<a =x>",
+		"<!--",
+		"<!-- <a> -->",
+		"<a><!-- x --></a>",
+		"<a><![CDATA[<b>]]]></a>",
+		"<<a>>",
+		"a < b > c",
+		"</a></b><c>",
+		"<a>\xff\xfe</a>",
+		"<a>\xe2\x82",
+		"```\n<a>\n```",
+		"<@set k=v/>",
+		"<a b=\"\u00e9\"\u00e9>\u4e16</a>",
+	}
+	for _, seed := range seeds {
+		f.Add(seed, uint8(1), uint16(0))
+		f.Add(seed, uint8(3), uint16(0xffff))
+	}
+
+	f.Fuzz(func(t *testing.T, input string, chunkSize uint8, options uint16) {
+		config := DefaultConfig()
+		config.BufferCleanupThreshold = 8
+		// Two bits per mode, one bit per flag
+		config.CommentMode = CommentMode(options & 0x3 % 3)
+		config.ElementCommentMode = CommentMode(options >> 2 & 0x3 % 3)
+		config.StrayCloseMode = StrayCloseMode(options >> 4 & 0x3 % 3)
+		config.DoubledBracketEscape = options&0x40 != 0
+		config.RespectCodeFences = options&0x80 != 0
+		config.PreserveFormatting = options&0x100 != 0
+		config.WhitespaceNodes = options&0x200 != 0
+		if options&0x400 != 0 {
+			config.MaxTokensPerAppend = 3
+		}
+		config.ChildNodes = options&0x800 != 0
+		config.DecodeEntities = options&0x1000 != 0
+		config.StripZeroWidth = options&0x2000 != 0
+		changeAllowed := options&0x4000 != 0
+		textMode := options&0x8000 != 0
+
+		parser := NewStreamXmlParserWithConfig(config)
+		size := max(int(chunkSize), 1)
+		for i := 0; i < len(input); i += size {
+			if i == len(input)/2/size*size {
+				// Change options mid-stream
+				if changeAllowed {
+					parser.SetAllowedElements([]string{"a", "tool"})
+				}
+				parser.SetTextMode(textMode)
+			}
+			parser.Append(input[i:min(i+size, len(input))])
+			parser.SetTextMode(false)
+		}
+
+		if _, err := parser.GetXmlNodes(); err != nil {
+			t.Fatalf("GetXmlNodes failed: %v", err)
+		}
+		if _, err := parser.GetText(); err != nil {
+			t.Fatalf("GetText failed: %v", err)
+		}
+		parser.GetAST()
+		parser.Finalize()
+		for _, node := range parser.NewCompletedNodes() {
+			node.Marshal()
+			node.InnerXML()
+		}
+		parser.GetText()
+
+		if config.MaxTokensPerAppend > 0 && !changeAllowed && !textMode {
+			// Processing in bounded steps gives the same nodes as processing at once
+			config.MaxTokensPerAppend = 0
+			reference, _, _ := Parse(input, config)
+			nodes, _ := parser.GetXmlNodes()
+			if len(nodes) != len(reference) {
+				t.Fatalf("expected %d nodes, got %d", len(reference), len(nodes))
+			}
+			for i := range nodes {
+				if got, want := nodes[i].Marshal(), reference[i].Marshal(); got != want {
+					t.Errorf("node %d: expected %q, got %q", i, want, got)
+				}
+			}
+		}
+	})
+}
+
+// TestUnquotedAttributeValueWithEquals tests that a follow-on attribute parses after an unquoted value containing '='
+func TestUnquotedAttributeValueWithEquals(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append(`<tool q=a=b `)
+	parser.Append(`name="x">body</tool>`)
+
+	node, _ := parser.GetXmlNode()
+	if node == nil {
+		t.Fatal("expected a node")
+	}
+	if node.Attributes["q"] != "a=b" || node.Attributes["name"] != "x" {
+		t.Errorf("expected q=a=b and name=x, got %v", node.Attributes)
+	}
+	if len(node.OrderedAttributes) != 2 || node.Content != "body" {
+		t.Errorf("unexpected node %+v", node)
+	}
+}
+
+// TestSetCanonicalAttributes tests lowercasing configured attribute values while leaving others untouched
+func TestSetCanonicalAttributes(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.SetCanonicalAttributes("tool", []string{"type", "mode"})
+	parser.Append(`<tool type="SEARCH" name="MyQuery" mode=Fast>Body TEXT</tool>`)
+	parser.Append(`<other type="SEARCH"/>`)
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	tool := nodes[0]
+	if tool.Attributes["type"] != "search" || tool.Attributes["mode"] != "fast" {
+		t.Errorf("expected canonical type and mode, got %v", tool.Attributes)
+	}
+	if tool.OrderedAttributes[0].Value != "search" {
+		t.Errorf("expected canonical ordered attribute, got %q", tool.OrderedAttributes[0].Value)
+	}
+	if tool.Attributes["name"] != "MyQuery" || tool.Content != "Body TEXT" {
+		t.Errorf("expected other attributes and content untouched, got %v %q", tool.Attributes, tool.Content)
+	}
+	if nodes[1].Attributes["type"] != "SEARCH" {
+		t.Errorf("expected other elements untouched, got %q", nodes[1].Attributes["type"])
+	}
+
+	parser.SetCanonicalAttributes("tool", nil)
+	parser.Append(`<tool type="SEARCH"/>`)
+	nodes, _ = parser.GetXmlNodes()
+	if got := nodes[2].Attributes["type"]; got != "SEARCH" {
+		t.Errorf("expected raw value after removing the setting, got %q", got)
+	}
+}
+
+// TestOnContentPrefix tests firing once at exactly N bytes and not firing for shorter content
+func TestOnContentPrefix(t *testing.T) {
+	parser := NewStreamXmlParser()
+	var prefixes []string
+	parser.OnContentPrefix("search", 5, func(prefix string) {
+		prefixes = append(prefixes, prefix)
+	})
+
+	parser.Append("<search>gola")
+	if len(prefixes) != 0 {
+		t.Fatalf("expected no callback before 5 bytes, got %q", prefixes)
+	}
+	parser.Append("ng")
+	if len(prefixes) != 1 || prefixes[0] != "golan" {
+		t.Fatalf("expected callback with 'golan', got %q", prefixes)
+	}
+	parser.Append(" streaming</search>")
+	if len(prefixes) != 1 {
+		t.Errorf("expected a single callback per element, got %q", prefixes)
+	}
+
+	// Short content, another element and a partial tag do not trigger it
+	parser.Append("<search>go</search><other>long content</other><search>ab<")
+	if len(prefixes) != 1 {
+		t.Errorf("expected no callback for short content, got %q", prefixes)
+	}
+
+	// Exactly N bytes in one chunk, nested markup counts as content
+	parser.Append("/search><search><b>x</b>y</search>")
+	if len(prefixes) != 2 || prefixes[1] != "<b>x<" {
+		t.Errorf("expected callback with '<b>x<', got %q", prefixes)
+	}
+
+	// A character cut by the N-th byte is included whole, also when it is
+	// split across appends
+	prefixes = nil
+	parser = NewStreamXmlParser()
+	parser.OnContentPrefix("search", 4, func(prefix string) {
+		prefixes = append(prefixes, prefix)
+	})
+	parser.Append("<search>日\xe6")
+	if len(prefixes) != 0 {
+		t.Fatalf("expected no callback before 4 bytes, got %q", prefixes)
+	}
+	parser.Append("\x9c\xac語</search>")
+	if len(prefixes) != 1 || prefixes[0] != "日本" {
+		t.Errorf("expected callback with '日本', got %q", prefixes)
+	}
+
+	// Nested children built with ChildNodes do not trigger it
+	prefixes = nil
+	config := DefaultConfig()
+	config.ChildNodes = true
+	parser = NewStreamXmlParserWithConfig(config)
+	parser.OnContentPrefix("search", 3, func(prefix string) {
+		prefixes = append(prefixes, prefix)
+	})
+	parser.Append("<tool><search>ünï</search></tool><search>ünï</search>")
+	if len(prefixes) != 1 || prefixes[0] != "ün" {
+		t.Errorf("expected a single callback for the top-level element, got %q", prefixes)
+	}
+}
+
+// TestLiveNodes tests that live nodes update in place while GetXmlNodes returns snapshots
+func TestLiveNodes(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append(`<a>1</a><tool name="x">par`)
+
+	live := parser.LiveNodes()
+	snapshot, _ := parser.GetXmlNodes()
+	if len(live) != 2 || len(snapshot) != 2 {
+		t.Fatalf("expected 2 nodes, got %d live and %d copied", len(live), len(snapshot))
+	}
+
+	parser.Append("tial")
+	if live[1].Content != "partial" || !live[1].Partial {
+		t.Errorf("expected live content 'partial', got %q", live[1].Content)
+	}
+	parser.Append(`</tool>`)
+	if live[1].Partial {
+		t.Errorf("expected live node to complete")
+	}
+
+	if snapshot[1].Content != "par" || !snapshot[1].Partial {
+		t.Errorf("expected snapshot to stay at 'par', got %q", snapshot[1].Content)
+	}
+	snapshot[1].Attributes["name"] = "changed"
+	if live[1].Attributes["name"] != "x" {
+		t.Errorf("expected snapshot attributes to be independent")
+	}
+	if nodes := parser.LiveNodes(); nodes[0] != live[0] || nodes[1] != live[1] {
+		t.Errorf("expected LiveNodes to return the same pointers")
+	}
+}
+
+// TestSetTerminatorElement tests content before the terminator and rejection of content after it
+func TestSetTerminatorElement(t *testing.T) {
+	sink := &recordingSink{}
+	parser := NewStreamXmlParser()
+	parser.AddSink(sink)
+	parser.SetTerminatorElement("done")
+
+	parser.Append("Result: <answer>42</answer> ")
+	if err := parser.Append("<do"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := parser.Append("ne/> ignored <x>1</x>"); err != nil {
+		t.Fatalf("expected the terminating Append to succeed, got %v", err)
+	}
+	if err := parser.Append("more"); !errors.Is(err, ErrStreamTerminated) {
+		t.Errorf("expected ErrStreamTerminated, got %v", err)
+	}
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 2 || nodes[0].Content != "42" || nodes[1].Name != "done" || nodes[1].Partial {
+		t.Fatalf("expected answer and done nodes, got %v", nodes)
+	}
+	if text, _ := parser.GetText(); text != "Result:  " {
+		t.Errorf("expected text before the terminator only, got %q", text)
+	}
+	if len(sink.events) == 0 || sink.events[len(sink.events)-1] != "done" {
+		t.Errorf("expected the stream to be finalized, got events %q", sink.events)
+	}
+	if err := parser.Finalize(); err != nil {
+		t.Errorf("expected Finalize after termination to be a no-op, got %v", err)
+	}
+}
+
+// TestFingerprint tests that fingerprints do not depend on chunking but do depend on the result
+func TestFingerprint(t *testing.T) {
+	input := "Intro text\n<tool name=\"search\" type=\"web\">query <b>x</b></tool>\nbetween <!-- c --> <ping a=1 b=2/> end <par"
+
+	fingerprint := func(input string, size int, config ParserConfig) uint64 {
+		parser := NewStreamXmlParserWithConfig(config)
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+		return parser.Fingerprint()
+	}
+
+	config := DefaultConfig()
+	config.CommentMode = CommentNode
+	whole := fingerprint(input, len(input), config)
+	for _, size := range []int{1, 2, 7} {
+		if got := fingerprint(input, size, config); got != whole {
+			t.Errorf("chunk size %d: expected %x, got %x", size, whole, got)
+		}
+	}
+
+	reordered := strings.Replace(input, `name="search" type="web"`, `type="web" name="search"`, 1)
+	if got := fingerprint(reordered, 3, config); got != whole {
+		t.Errorf("expected attribute order not to matter, got %x and %x", whole, got)
+	}
+	changed := strings.Replace(input, "query", "querx", 1)
+	if got := fingerprint(changed, len(changed), config); got == whole {
+		t.Errorf("expected different content to change the fingerprint")
+	}
+	moved := strings.Replace(input, "between <!--", "between<!--", 1)
+	if got := fingerprint(moved, len(moved), config); got == whole {
+		t.Errorf("expected different text to change the fingerprint")
+	}
+
+	config.ChildNodes = true
+	seen := map[uint64]string{}
+	for _, tree := range []string{"<a>x<b>y</b></a>", "<a>x<c>y</c></a>", "<a>x<b>z</b></a>", "<a><b>y</b>x</a>", "<a>x<b y=1>y</b></a>"} {
+		got := fingerprint(tree, 2, config)
+		if other, ok := seen[got]; ok {
+			t.Errorf("expected different children to change the fingerprint, got the same for %s and %s", other, tree)
+		}
+		seen[got] = tree
+	}
+}
+
+// TestMaxTokensPerAppend tests that a giant chunk is processed across Append and Drain calls
+func TestMaxTokensPerAppend(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&input, "item %d <tool n=%d>body</tool>\n", i, i)
+	}
+
+	config := DefaultConfig()
+	config.MaxTokensPerAppend = 50
+	config.MaxBufferSize = 1024 * 1024
+	parser := NewStreamXmlParserWithConfig(config)
+
+	if err := parser.Append(input.String()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) == 0 || len(nodes) > 10 {
+		t.Fatalf("expected a bounded first batch, got %d nodes", len(nodes))
+	}
+
+	calls := 1
+	for {
+		more, err := parser.Drain()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		calls++
+		if !more {
+			break
+		}
+		if calls > 1000 {
+			t.Fatal("Drain did not finish")
+		}
+	}
+	if calls < 10 {
+		t.Errorf("expected processing to span many calls, got %d", calls)
+	}
+
+	nodes, _ = parser.GetXmlNodes()
+	if len(nodes) != 200 {
+		t.Fatalf("expected 200 nodes, got %d", len(nodes))
+	}
+	for i, node := range nodes {
+		if n, _ := node.AttrInt("n"); n != i || node.Partial {
+			t.Errorf("node %d: unexpected %+v", i, node)
+		}
+	}
+	if more, _ := parser.Drain(); more {
+		t.Errorf("expected nothing left")
+	}
+
+	// Finalize processes everything regardless of the limit
+	parser = NewStreamXmlParserWithConfig(config)
+	parser.Append(input.String())
+	parser.Finalize()
+	if nodes, _ := parser.GetXmlNodes(); len(nodes) != 200 {
+		t.Errorf("expected 200 nodes after Finalize, got %d", len(nodes))
+	}
+
+	// A limit reached inside a tag does not split it across buffer cleanup
+	config.MaxTokensPerAppend = 3
+	config.BufferCleanupThreshold = 1
+	parser = NewStreamXmlParserWithConfig(config)
+	parser.Append(`<tool name="x">content</tool>`)
+	for range 20 {
+		parser.Append("")
+	}
+	node, _ := parser.GetXmlNode()
+	if node == nil || node.Name != "tool" || node.Attributes["name"] != "x" || node.Content != "content" || node.Partial {
+		t.Errorf("expected the tool node intact, got %+v", node)
+	}
+}
+
+// TestLenientLimits tests depth-exceeded handling under strict and lenient modes
+func TestLenientLimits(t *testing.T) {
+	input := "<plan><step><detail>x</detail></step></plan> after <next/>"
+
+	// Strict: Append returns the error
+	config := DefaultConfig()
+	config.MaxDepth = 2
+	parser := NewStreamXmlParserWithConfig(config)
+	if err := parser.Append(input); !errors.Is(err, ErrMaxDepthExceeded) {
+		t.Errorf("expected ErrMaxDepthExceeded in strict mode, got %v", err)
+	}
+	if len(parser.Warnings()) != 0 {
+		t.Errorf("expected no warnings in strict mode, got %v", parser.Warnings())
+	}
+
+	// Lenient: a warning is recorded and parsing continues
+	config.LenientLimits = true
+	parser = NewStreamXmlParserWithConfig(config)
+	for i := 0; i < len(input); i += 6 {
+		if err := parser.Append(input[i:min(i+6, len(input))]); err != nil {
+			t.Fatalf("unexpected error in lenient mode: %v", err)
+		}
+	}
+
+	warnings := parser.Warnings()
+	if len(warnings) != 1 || !errors.Is(warnings[0], ErrMaxDepthExceeded) || warnings[0].Detail != "detail" {
+		t.Fatalf("expected one depth warning for <detail>, got %v", warnings)
+	}
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	}
+	if nodes[0].Partial || nodes[0].Content != "<step><detail>x</detail></step>" {
+		t.Errorf("expected flattened content, got %+v", nodes[0])
+	}
+	if nodes[1].Name != "next" || nodes[1].Partial {
+		t.Errorf("expected parsing to continue after the element, got %+v", nodes[1])
+	}
+
+	// Element limits are lenient too
+	parser = NewStreamXmlParserWithConfig(config)
+	parser.SetElementMaxDepth("plan", 1)
+	if err := parser.Append(input); err != nil {
+		t.Errorf("unexpected error in lenient mode: %v", err)
+	}
+	if warnings := parser.Warnings(); len(warnings) != 2 {
+		t.Errorf("expected 2 warnings, got %v", warnings)
+	}
+}
+
+// TestSetContentUnwrap tests removing a code fence wrapper from completed content
+func TestSetContentUnwrap(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.SetContentUnwrap("tool", "```json\n", "\n```")
+
+	input := "<tool>\n```json\n{\"a\": 1}\n```\n</tool>" +
+		"<tool>{\"b\": 2}</tool>" +
+		"<tool>```json\n{\"c\": 3}</tool>" +
+		"<tool>```</tool>" +
+		"<other>```json\n{}\n```</other>"
+	for i := 0; i < len(input); i += 4 {
+		parser.Append(input[i:min(i+4, len(input))])
+	}
+
+	nodes, _ := parser.GetXmlNodes()
+	expected := []string{
+		`{"a": 1}`,
+		`{"b": 2}`,
+		"```json\n{\"c\": 3}",
+		"```",
+		"```json\n{}\n```",
+	}
+	if len(nodes) != len(expected) {
+		t.Fatalf("expected %d nodes, got %d", len(expected), len(nodes))
+	}
+	for i, node := range nodes {
+		if node.Content != expected[i] {
+			t.Errorf("node %d: expected %q, got %q", i, expected[i], node.Content)
+		}
+	}
+
+	// Content stays wrapped while the node is partial
+	parser.Append("<tool>```json\n{}\n```")
+	if live := parser.LiveNodes(); live[len(live)-1].Content != "```json\n{}\n```" {
+		t.Errorf("expected partial content to be untouched, got %q", live[len(live)-1].Content)
+	}
+}
+
+// TestDeclaredEncoding tests reading the encoding from an XML declaration with and without one
+func TestDeclaredEncoding(t *testing.T) {
+	tests := []struct {
+		input    string
+		encoding string
+		ok       bool
+	}{
+		{`<?xml version="1.0" encoding="UTF-16"?><tool>x</tool>`, "UTF-16", true},
+		{"\n<?xml version='1.0' encoding='iso-8859-1' ?>\n<tool>x</tool>", "iso-8859-1", true},
+		{`<?xml version="1.0"?><tool>x</tool>`, "", false},
+		{`<tool>x</tool>`, "", false},
+	}
+
+	for _, tt := range tests {
+		for _, size := range []int{len(tt.input), 3} {
+			parser := NewStreamXmlParser()
+			for i := 0; i < len(tt.input); i += size {
+				parser.Append(tt.input[i:min(i+size, len(tt.input))])
+			}
+
+			encoding, ok := parser.DeclaredEncoding()
+			if encoding != tt.encoding || ok != tt.ok {
+				t.Errorf("%q (chunk size %d): expected %q, %v, got %q, %v", tt.input, size, tt.encoding, tt.ok, encoding, ok)
+			}
+			nodes, _ := parser.GetXmlNodes()
+			if len(nodes) != 1 || nodes[0].Name != "tool" || nodes[0].Content != "x" || nodes[0].Ordinal != 0 {
+				t.Errorf("%q (chunk size %d): expected only the tool node, got %v", tt.input, size, nodes)
+			}
+		}
+	}
+}
+
+// TestHasElement tests presence of empty, non-empty, partial and absent elements
+func TestHasElement(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("text <empty></empty> <self/> <tool>content</tool> <stream>par")
+
+	for _, name := range []string{"empty", "self", "tool", "stream"} {
+		if !parser.HasElement(name) {
+			t.Errorf("expected <%s> to be present", name)
+		}
+	}
+	for _, name := range []string{"missing", "too", ""} {
+		if parser.HasElement(name) {
+			t.Errorf("expected %q to be absent", name)
+		}
+	}
+
+	node, _ := parser.GetXmlNode()
+	if node.Name != "empty" || node.Content != "" {
+		t.Errorf("expected the empty element first, got %+v", node)
+	}
+}
+
+// TestLenientTags tests that a malformed tag between two good elements is dropped with a warning
+func TestLenientTags(t *testing.T) {
+	malformed := []string{`<=bad>`, `<>`, `<"oops" x=1>`, `<1bad/>`, `<-x>`}
+
+	for _, tag := range malformed {
+		input := "<a>1</a> " + tag + " <b>2<c>3</c></b>"
+
+		config := DefaultConfig()
+		config.LenientTags = true
+		parser := NewStreamXmlParserWithConfig(config)
+		for i := 0; i < len(input); i += 3 {
+			parser.Append(input[i:min(i+3, len(input))])
+		}
+
+		nodes, _ := parser.GetXmlNodes()
+		if len(nodes) != 2 || nodes[0].Name != "a" || nodes[1].Name != "b" {
+			t.Fatalf("%s: expected nodes a and b, got %v", tag, nodes)
+		}
+		if nodes[0].Partial || nodes[1].Partial || nodes[1].Content != "2<c>3</c>" {
+			t.Errorf("%s: expected both elements complete, got %+v", tag, nodes[1])
+		}
+		warnings := parser.Warnings()
+		if len(warnings) != 1 || !errors.Is(warnings[0], ErrMalformedTag) || warnings[0].Detail != tag {
+			t.Errorf("%s: expected one malformed tag warning, got %v", tag, warnings)
+		}
+		if text, _ := parser.GetText(); text != "  " {
+			t.Errorf("%s: expected only the surrounding spaces as text, got %q", tag, text)
+		}
+	}
+
+	// Without the option the malformed tag opens an element
+	parser := NewStreamXmlParser()
+	parser.Append("<a>1</a> <=bad> <b>2</b>")
+	if nodes, _ := parser.GetXmlNodes(); len(nodes) != 2 || nodes[1].Name != "=bad" {
+		t.Errorf("expected default handling to be unchanged, got %v", nodes)
+	}
+}
+
+// TestStripZeroWidth tests removing zero-width characters split across appends
+func TestStripZeroWidth(t *testing.T) {
+	input := "\ufeffHi\u200b <\u200btool name=\"a\u200cb\"\u200d>x\u200by\ufeff</tool\u200b> — done\u200b"
+
+	for _, size := range []int{1, 2, 5, len(input)} {
+		config := DefaultConfig()
+		config.StripZeroWidth = true
+		parser := NewStreamXmlParserWithConfig(config)
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+		parser.Finalize()
+
+		nodes, _ := parser.GetXmlNodes()
+		if len(nodes) != 1 || nodes[0].Name != "tool" || nodes[0].Partial {
+			t.Fatalf("size %d: expected complete tool node, got %v", size, nodes)
+		}
+		if nodes[0].Attributes["name"] != "ab" || nodes[0].Content != "xy" {
+			t.Errorf("size %d: expected zero-width characters removed, got %+v", size, nodes[0])
+		}
+		if text, _ := parser.GetText(); text != "Hi  — done" {
+			t.Errorf("size %d: unexpected text %q", size, text)
+		}
+	}
+
+	// Without the option the characters are kept
+	parser := NewStreamXmlParser()
+	parser.Append("<tool>x\u200b</tool>")
+	if nodes, _ := parser.GetXmlNodes(); len(nodes) != 1 || nodes[0].Content != "x\u200b" {
+		t.Errorf("expected default handling to be unchanged, got %v", nodes)
+	}
+}
+
+// TestDropContent tests discarding the rest of an open element's content
+func TestDropContent(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append(`<file path="a.txt">line 1` + "\n")
+
+	parser.DropContent("other")
+	parser.DropContent("file")
+	parser.Append("line 2 <b>bold</b>\n<")
+	parser.Append("/file>after")
+
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 1 || nodes[0].Partial {
+		t.Fatalf("expected the file element to complete, got %v", nodes)
+	}
+	if nodes[0].Content != "line 1\n" || !nodes[0].ContentDropped {
+		t.Errorf("expected content to stop after the first line, got %+v", nodes[0])
+	}
+	if nodes[0].InnerXML() != "line 1\n" {
+		t.Errorf("expected inner XML to stop too, got %q", nodes[0].InnerXML())
+	}
+	if text, _ := parser.GetText(); text != "after" {
+		t.Errorf("expected text after the element, got %q", text)
+	}
+
+	// The next element of the same name collects content again
+	parser.Append(`<file path="b.txt">x</file>`)
+	if nodes, _ := parser.GetXmlNodes(); len(nodes) != 2 || nodes[1].Content != "x" || nodes[1].ContentDropped {
+		t.Errorf("expected the next element unaffected, got %+v", nodes[1])
+	}
+}
+
+// TestRawInput tests returning the appended data with and without RetainRawInput
+func TestRawInput(t *testing.T) {
+	chunks := []string{"Hi \u200b", "<tool name=\"a\">", strings.Repeat("x", 100), "</tool>", " <b/> bye"}
+	for range 10 {
+		chunks = append(chunks, "<tool>more</tool>")
+	}
+
+	config := DefaultConfig()
+	config.RetainRawInput = true
+	config.StripZeroWidth = true
+	config.BufferCleanupThreshold = 16
+	parser := NewStreamXmlParserWithConfig(config)
+	for _, chunk := range chunks {
+		parser.Append(chunk)
+	}
+
+	want := strings.Join(chunks, "")
+	if parser.tokenizer.discarded == 0 {
+		t.Fatal("expected buffer cleanup to have run")
+	}
+	if got := parser.RawInput(); got != want {
+		t.Errorf("expected raw input %q, got %q", want, got)
+	}
+
+	// RetainInput keeps the input for Reparse only
+	config.RetainRawInput = false
+	config.RetainInput = true
+	parser = NewStreamXmlParserWithConfig(config)
+	for _, chunk := range chunks {
+		parser.Append(chunk)
+	}
+	if got := parser.RawInput(); got != "" {
+		t.Errorf("expected no raw input without RetainRawInput, got %d bytes", len(got))
+	}
+	if _, err := parser.Reparse(DefaultConfig()); err != nil {
+		t.Errorf("expected Reparse to work with RetainInput, got %v", err)
+	}
+
+	// Without RetainRawInput the input is only available until cleanup
+	parser = NewStreamXmlParser()
+	parser.Append("<a>1</a>")
+	if got := parser.RawInput(); got != "<a>1</a>" {
+		t.Errorf("expected raw input before cleanup, got %q", got)
+	}
+	parser.Append(strings.Repeat("<a>1</a>", 200))
+	if got := parser.RawInput(); got != "" {
+		t.Errorf("expected no raw input after cleanup, got %d bytes", len(got))
+	}
+}
+
+// TestOnTopLevelTransition tests the callback for changes of the open top-level element
+func TestOnTopLevelTransition(t *testing.T) {
+	input := "text<a/><b>x<c/>y</b><b/>text"
+
+	for _, size := range []int{1, len(input)} {
+		var transitions []string
+		parser := NewStreamXmlParser()
+		parser.OnTopLevelTransition(func(from, to string) {
+			transitions = append(transitions, from+">"+to)
+		})
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+
+		expected := ">a a>b b>"
+		if strings.Join(transitions, " ") != expected {
+			t.Errorf("size %d: expected transitions %v, got %v", size, expected, transitions)
+		}
+	}
+
+	// A leading element transitions from text
+	var transitions []string
+	parser := NewStreamXmlParser()
+	parser.OnTopLevelTransition(func(from, to string) {
+		transitions = append(transitions, from+">"+to)
+	})
+	parser.Append("<a>1</a>")
+	if strings.Join(transitions, " ") != ">a" {
+		t.Errorf("expected a single transition to a, got %v", transitions)
+	}
+}
+
+// TestParse tests that Parse matches appending the input and finalizing
+func TestParse(t *testing.T) {
+	inputs := []string{
+		"Hi <tool name=\"a\">q</tool> bye",
+		"<a>1<b>2</b></a><c/>",
+		"text only",
+		"</stray> <tool name=\"x",
+		"a < b and <open>never closed",
+		"",
+	}
+
+	for _, input := range inputs {
+		nodes, text, err := Parse(input, DefaultConfig())
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", input, err)
+		}
+
+		parser := NewStreamXmlParser()
+		for i := range len(input) {
+			parser.Append(input[i : i+1])
+		}
+		parser.Finalize()
+		wantNodes, _ := parser.GetXmlNodes()
+		wantText, _ := parser.GetText()
+
+		if text != wantText {
+			t.Errorf("%q: expected text %q, got %q", input, wantText, text)
+		}
+		if len(nodes) != len(wantNodes) {
+			t.Fatalf("%q: expected %d nodes, got %d", input, len(wantNodes), len(nodes))
+		}
+		for i := range nodes {
+			if nodes[i].Marshal() != wantNodes[i].Marshal() || nodes[i].Partial != wantNodes[i].Partial {
+				t.Errorf("%q: node %d: expected %+v, got %+v", input, i, wantNodes[i], nodes[i])
+			}
+		}
+	}
+
+	// Errors are returned with what was parsed before them
+	config := DefaultConfig()
+	config.MaxDepth = 1
+	nodes, text, err := Parse("x<a><b>", config)
+	if !errors.Is(err, ErrMaxDepthExceeded) || text != "x" || len(nodes) != 1 {
+		t.Errorf("expected depth error after text and one node, got %v %q %v", nodes, text, err)
+	}
+	if _, _, err := Parse("x", ParserConfig{}); !errors.Is(err, ErrInvalidConfiguration) {
+		t.Errorf("expected ErrInvalidConfiguration, got %v", err)
+	}
+}
+
+// TestOnNodeOpen tests the open callback and its order relative to sink events
+func TestOnNodeOpen(t *testing.T) {
+	input := `Hi <tool name="search">query</tool><done/>`
+
+	for _, size := range []int{1, 4, len(input)} {
+		parser := NewStreamXmlParser()
+		sink := &recordingSink{}
+		parser.AddSink(sink)
+		parser.OnNodeOpen(func(node *XmlNode) {
+			sink.events = append(sink.events, "open:"+node.Name+":"+node.Attributes["name"]+"="+node.Content)
+		})
+		parser.OnContentPrefix("tool", 1, func(prefix string) {
+			sink.events = append(sink.events, "content:"+prefix)
+		})
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+
+		// Top-level text arrives in chunk-size dependent pieces
+		var events []string
+		for _, event := range sink.events {
+			if !strings.HasPrefix(event, "text:") {
+				events = append(events, event)
+			}
+		}
+		expected := "open:tool:search= content:q node:tool=query open:done:= node:done="
+		if got := strings.Join(events, " "); got != expected {
+			t.Errorf("size %d: expected events %q, got %q", size, expected, got)
+		}
+	}
+}
+
+// TestBufferCompactionLongStreams tests that long text, long element content
+// and many elements stay parsed correctly while the buffer is compacted
+func TestBufferCompactionLongStreams(t *testing.T) {
+	const total = 10 * 1024 * 1024
+	const chunkSize = 4096
+	bound := chunkSize + 4*DefaultConfig().BufferCleanupThreshold
+
+	stream := func(t *testing.T, parser *StreamXmlParser, input string) {
+		for i := 0; i < len(input); i += chunkSize {
+			if err := parser.Append(input[i:min(i+chunkSize, len(input))]); err != nil {
+				t.Fatalf("unexpected error at %d: %v", i, err)
+			}
+			if n := len(parser.tokenizer.GetBuffer()); n > bound {
+				t.Fatalf("buffer grew to %d bytes at %d, expected at most %d", n, i, bound)
+			}
+		}
+		parser.Finalize()
+	}
+
+	t.Run("text", func(t *testing.T) {
+		input := strings.Repeat("a", total)
+		parser := NewStreamXmlParser()
+		stream(t, parser, input)
+		if text, _ := parser.GetText(); text != input {
+			t.Errorf("expected %d bytes of text, got %d", len(input), len(text))
+		}
+	})
+
+	t.Run("content", func(t *testing.T) {
+		body := strings.Repeat("b", total)
+		parser := NewStreamXmlParser()
+		stream(t, parser, "<file>"+body+"</file>")
+		node, _ := parser.GetXmlNode()
+		if node == nil || node.Partial || node.Content != body || node.InnerXML() != body {
+			t.Errorf("expected the complete file element with %d bytes of content", len(body))
+		}
+	})
+
+	t.Run("elements", func(t *testing.T) {
+		unit := `text <tool name="x">body</tool> `
+		count := total / len(unit)
+		parser := NewStreamXmlParser()
+		stream(t, parser, strings.Repeat(unit, count))
+		nodes := parser.LiveNodes()
+		if len(nodes) != count {
+			t.Fatalf("expected %d nodes, got %d", count, len(nodes))
+		}
+		last := nodes[len(nodes)-1]
+		if last.Partial || last.Attributes["name"] != "x" || last.Content != "body" {
+			t.Errorf("expected the last node intact, got %+v", last)
+		}
+	})
+}
+
+// TestAttributeValuesWithOtherQuote tests that a quote of the other kind does
+// not end an attribute value, whole or split across chunks
+func TestAttributeValuesWithOtherQuote(t *testing.T) {
+	tests := []struct {
+		input string
+		want  map[string]string
+	}{
+		{`<tool msg="it's fine">x</tool>`, map[string]string{"msg": "it's fine"}},
+		{`<tool msg='say "hi"'>x</tool>`, map[string]string{"msg": `say "hi"`}},
+		{`<tool a="'" b='"' c="x'y'z">x</tool>`, map[string]string{"a": "'", "b": `"`, "c": "x'y'z"}},
+		{`<tool msg="a > b, 'c'" id='1'/>`, map[string]string{"msg": "a > b, 'c'", "id": "1"}},
+	}
+
+	for _, tt := range tests {
+		for _, size := range []int{1, 2, 3, len(tt.input)} {
+			parser := NewStreamXmlParser()
+			for i := 0; i < len(tt.input); i += size {
+				parser.Append(tt.input[i:min(i+size, len(tt.input))])
+			}
+
+			node, _ := parser.GetXmlNode()
+			if node == nil || node.Partial || node.Name != "tool" {
+				t.Fatalf("%s (size %d): expected a complete tool node, got %+v", tt.input, size, node)
+			}
+			if fmt.Sprint(node.Attributes) != fmt.Sprint(tt.want) {
+				t.Errorf("%s (size %d): expected attributes %v, got %v", tt.input, size, tt.want, node.Attributes)
+			}
+		}
+	}
+}
+
+// TestTextifiedTags tests recording tags kept as text along with the reason
+func TestTextifiedTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(*ParserConfig)
+		input  string
+		want   []TextifiedTag
+	}{
+		{
+			name:   "disallowed",
+			config: func(c *ParserConfig) { c.AllowedElements = []string{"tool"} },
+			input:  "a <b>x</b> <tool>y</tool>",
+			want:   []TextifiedTag{{"b", TextifyDisallowed, 2}, {"b", TextifyDisallowed, 6}},
+		},
+		{
+			name:  "malformed",
+			input: "if a < b <tool>y</tool> then < 3",
+			want:  []TextifiedTag{{"", TextifyMalformed, 5}, {"", TextifyMalformed, 29}},
+		},
+		{
+			name:   "stray close",
+			config: func(c *ParserConfig) { c.StrayCloseMode = StrayCloseText },
+			input:  "done</tool> <a/>",
+			want:   []TextifiedTag{{"tool", TextifyStrayClose, 4}},
+		},
+		{
+			name:  "elements only",
+			input: "<a x=\"<b>\">1<c/></a>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			if tt.config != nil {
+				tt.config(&config)
+			}
+			parser := NewStreamXmlParserWithConfig(config)
+			for i := range len(tt.input) {
+				parser.Append(tt.input[i : i+1])
+			}
+			parser.Finalize()
+
+			if got := parser.TextifiedTags(); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestTextifiedTagsValidator tests that tags rejected by the tag validator are
+// kept as text and reported with TextifyUnvalidated
+func TestTextifiedTagsValidator(t *testing.T) {
+	input := `<tool name="rm">x</tool> <tool name="ls">y</tool> <note/>`
+	for _, size := range []int{1, len(input)} {
+		config := DefaultConfig()
+		config.StrayCloseMode = StrayCloseText
+		parser := NewStreamXmlParserWithConfig(config)
+		parser.SetTagValidator(func(name string, attributes map[string]string) bool {
+			return name != "tool" || attributes["name"] != "rm"
+		})
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+		parser.Finalize()
+
+		want := []TextifiedTag{{"tool", TextifyUnvalidated, 0}, {"tool", TextifyStrayClose, 17}}
+		if got := parser.TextifiedTags(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("size %d: expected %v, got %v", size, want, got)
+		}
+		nodes, _ := parser.GetXmlNodes()
+		if len(nodes) != 2 || nodes[0].Attributes["name"] != "ls" || nodes[1].Name != "note" {
+			t.Errorf("size %d: expected only the accepted elements, got %d nodes", size, len(nodes))
+		}
+		if text, _ := parser.GetText(); !strings.HasPrefix(text, `<tool name="rm">x</tool> `) {
+			t.Errorf("size %d: expected the rejected element as text, got %q", size, text)
+		}
+	}
+}
+
+// TestMaxBufferSize tests rejecting appends that would exceed MaxBufferSize
+func TestMaxBufferSize(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxBufferSize = 1024
+	config.StripZeroWidth = true
+
+	// A single Append larger than the limit is rejected without changing anything
+	parser := NewStreamXmlParserWithConfig(config)
+	parser.Append("Hi \xe2\x80")
+	if err := parser.Append(strings.Repeat("x", 1025)); !errors.Is(err, ErrMaxBufferSizeExceeded) {
+		t.Fatalf("expected ErrMaxBufferSizeExceeded, got %v", err)
+	}
+	if buffer := parser.tokenizer.GetBuffer(); buffer != "Hi " || parser.zeroWidthPending != "\xe2\x80" {
+		t.Errorf("expected state unchanged after rejection, got %q", buffer)
+	}
+	parser.Append("\x8b<a>1</a>")
+	if text, _ := parser.GetText(); text != "Hi " {
+		t.Errorf("expected the parser to recover, got text %q", text)
+	}
+
+	// Consumed data does not count towards the limit
+	parser = NewStreamXmlParserWithConfig(config)
+	for i := range 100 {
+		if err := parser.Append(fmt.Sprintf("<a>%03d</a> text ", i)); err != nil {
+			t.Fatalf("unexpected error after %d chunks: %v", i, err)
+		}
+	}
+	if nodes, _ := parser.GetXmlNodes(); len(nodes) != 100 {
+		t.Errorf("expected 100 nodes, got %d", len(nodes))
+	}
+
+	// A tag that never closes eventually hits the limit
+	parser = NewStreamXmlParserWithConfig(config)
+	parser.Append(`<tool name="`)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		before := parser.tokenizer.GetBuffer()
+		if err = parser.Append(strings.Repeat("y", 100)); err != nil && parser.tokenizer.GetBuffer() != before {
+			t.Error("expected the buffer unchanged after rejection")
+		}
+	}
+	if !errors.Is(err, ErrMaxBufferSizeExceeded) || len(parser.tokenizer.GetBuffer()) > 1024 {
+		t.Errorf("expected the unclosed tag to hit the limit, got %v", err)
+	}
+}
+
+// TestReset tests that Reset clears per-stream state but keeps options
+func TestReset(t *testing.T) {
+	config := DefaultConfig()
+	config.RetainInput = true
+	config.WarnOnEmptyAttributeValue = true
+
+	first := `<?xml version="1.0" encoding="latin1"?>Hi <a x= >1</a> < b <tool name="q">open`
+	second := `Next <tool name="s">2</tool><b/> tail`
+
+	parser := NewStreamXmlParserWithConfig(config)
+	parser.SetRequiredAttributes("tool", []string{"name"})
+	var opened []string
+	parser.OnNodeOpen(func(node *XmlNode) { opened = append(opened, node.Name) })
+	events := parser.Events()
+	parser.Append(first)
+	parser.NewCompletedNodes()
+	parser.Pause()
+
+	parser.Reset()
+	for range events {
+		// The old event channel is closed
+	}
+	parser.Append(second)
+	parser.Finalize()
+
+	fresh := NewStreamXmlParserWithConfig(config)
+	fresh.Append(second)
+	fresh.Finalize()
+
+	describe := func(p *StreamXmlParser) string {
+		var parts []string
+		for _, node := range p.GetAST() {
+			if node.XmlNode != nil {
+				parts = append(parts, fmt.Sprintf("%d:%s:%v", node.Position, node.XmlNode.Marshal(), node.XmlNode.Partial))
+			} else {
+				parts = append(parts, fmt.Sprintf("%d:%q", node.Position, node.Text))
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+	if got, want := describe(parser), describe(fresh); got != want {
+		t.Errorf("expected AST %s, got %s", want, got)
+	}
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 2 || nodes[0].Ordinal != 0 || nodes[0].StartPos != 5 || nodes[0].Content != "2" {
+		t.Errorf("expected nodes numbered and positioned from the new stream, got %+v", nodes)
+	}
+	if parser.Fingerprint() != fresh.Fingerprint() {
+		t.Error("expected the same fingerprint as a fresh parser")
+	}
+	if got, want := fmt.Sprint(parser.Metrics()), fmt.Sprint(fresh.Metrics()); got != want {
+		t.Errorf("expected the metrics of a fresh parser %s, got %s", want, got)
+	}
+	if len(parser.Warnings()) != 0 || len(parser.TextifiedTags()) != 0 {
+		t.Errorf("expected no leftover warnings, got %v %v", parser.Warnings(), parser.TextifiedTags())
+	}
+	if _, ok := parser.DeclaredEncoding(); ok || parser.RawInput() != second {
+		t.Errorf("expected no leftover declaration or input, got %q", parser.RawInput())
+	}
+	if completed := parser.NewCompletedNodes(); len(completed) != 2 {
+		t.Errorf("expected both new nodes to be reported once, got %d", len(completed))
+	}
+
+	// Options and callbacks carry over
+	if strings.Join(opened, ",") != "a,tool,tool,b" {
+		t.Errorf("expected the open callback to keep firing, got %v", opened)
+	}
+	parser.Reset()
+	if err := parser.Append("<tool>x</tool>"); !errors.Is(err, ErrMissingRequiredAttribute) {
+		t.Errorf("expected required attributes to carry over, got %v", err)
+	}
+}
+
+// TestRequireSpaceBeforeSelfClose tests self-closing tags without a space before "/>"
+func TestRequireSpaceBeforeSelfClose(t *testing.T) {
+	input := `<tool/> <tool name="a"/><tool />` + "<tool\t/><a>x<b/>y<c /></a>"
+
+	tests := []struct {
+		name    string
+		require bool
+		lenient bool
+		nodes   string
+		text    string
+		content string
+	}{
+		{"off", false, false, "tool tool tool tool a", " ", "x<b/>y<c />"},
+		{"text", true, false, "tool tool a", `<tool/> <tool name="a"/>`, "x<b/>y<c />"},
+		{"lenient", true, true, "tool tool a", " ", "xy<c />"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.RequireSpaceBeforeSelfClose = tt.require
+			config.LenientTags = tt.lenient
+			parser := NewStreamXmlParserWithConfig(config)
+			for i := range len(input) {
+				parser.Append(input[i : i+1])
+			}
+			parser.Finalize()
+
+			nodes, _ := parser.GetXmlNodes()
+			var names []string
+			for _, node := range nodes {
+				names = append(names, node.Name)
+			}
+			if strings.Join(names, " ") != tt.nodes {
+				t.Errorf("expected nodes %q, got %q", tt.nodes, names)
+			}
+			if text, _ := parser.GetText(); text != tt.text {
+				t.Errorf("expected text %q, got %q", tt.text, text)
+			}
+			if last := nodes[len(nodes)-1]; last.Content != tt.content {
+				t.Errorf("expected content %q, got %q", tt.content, last.Content)
+			}
+
+			malformed := 0
+			if tt.require {
+				malformed = 3
+			}
+			if tt.lenient && len(parser.Warnings()) != malformed {
+				t.Errorf("expected %d warnings, got %v", malformed, parser.Warnings())
+			}
+			if !tt.lenient && len(parser.TextifiedTags()) != malformed {
+				t.Errorf("expected %d textified tags, got %v", malformed, parser.TextifiedTags())
+			}
+		})
+	}
+}
+
+// TestOnNodeCompleteAndUpdate tests the complete and update callbacks
+func TestOnNodeCompleteAndUpdate(t *testing.T) {
+	input := `Hi <tool name="a">one</tool> <done/> <tool name="b">two<x/></tool> <open>tail`
+
+	for _, size := range []int{1, 5, len(input)} {
+		parser := NewStreamXmlParser()
+		var completed []*XmlNode
+		updates := map[string][]string{}
+		parser.OnNodeComplete(func(node *XmlNode) {
+			completed = append(completed, node)
+		})
+		parser.OnNodeUpdate(func(node *XmlNode) {
+			if !node.Partial {
+				t.Errorf("size %d: expected updates only for partial nodes, got %+v", size, node)
+			}
+			updates[node.Name] = append(updates[node.Name], node.Content)
+		})
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+		parser.Finalize()
+
+		var names []string
+		for _, node := range completed {
+			names = append(names, node.Name+"="+node.Content)
+		}
+		if got := strings.Join(names, " "); got != "tool=one done= tool=two<x/>" {
+			t.Errorf("size %d: expected each node to complete once, got %q", size, got)
+		}
+
+		// Copies are unaffected by later parsing
+		completed[0].Content = "changed"
+		if nodes := parser.LiveNodes(); nodes[0].Content != "one" {
+			t.Errorf("size %d: expected the callback to receive a copy", size)
+		}
+
+		if last := updates["open"]; len(last) == 0 || last[len(last)-1] != "tail" {
+			t.Errorf("size %d: expected updates for the open element, got %q", size, last)
+		}
+		if size == 1 && len(updates["tool"]) < len("one")+len("two<x/>") {
+			t.Errorf("expected an update per content byte, got %q", updates["tool"])
+		}
+		if len(updates["done"]) != 0 {
+			t.Errorf("size %d: expected no updates for a self-closing element, got %q", size, updates["done"])
+		}
+	}
+}
+
+// TestConfigDecodeEntities tests decoding entities in text, content and attributes
+func TestConfigDecodeEntities(t *testing.T) {
+	input := `Tom &amp; Jerry &#169; &notanentity; a&b <tool q="x &lt; y" r='&#x2014;'>if a &lt; b &amp;&amp; c &gt; d<i>&quot;</i></tool> &amp`
+
+	for _, size := range []int{1, 2, 3, 7, len(input)} {
+		config := DefaultConfig()
+		config.DecodeEntities = true
+		parser := NewStreamXmlParserWithConfig(config)
+		var chunks strings.Builder
+		parser.OnTextChunk(func(chunk string) { chunks.WriteString(chunk) })
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+		parser.Finalize()
+
+		wantText := "Tom & Jerry © &notanentity; a&b  &amp"
+		if text, _ := parser.GetText(); text != wantText {
+			t.Errorf("size %d: expected text %q, got %q", size, wantText, text)
+		}
+		if chunks.String() != wantText {
+			t.Errorf("size %d: expected text chunks %q, got %q", size, wantText, chunks.String())
+		}
+		node, _ := parser.GetXmlNode()
+		if node == nil || node.Content != `if a < b && c > d<i>"</i>` {
+			t.Fatalf("size %d: unexpected node %+v", size, node)
+		}
+		if node.Attributes["q"] != "x < y" || node.Attributes["r"] != "—" {
+			t.Errorf("size %d: expected decoded attributes, got %v", size, node.Attributes)
+		}
+		if node.InnerXML() != "if a &lt; b &amp;&amp; c &gt; d<i>&quot;</i>" {
+			t.Errorf("size %d: expected inner XML as written, got %q", size, node.InnerXML())
+		}
+	}
+
+	// Decoding is off by default
+	parser := NewStreamXmlParser()
+	parser.Append("a &amp; b")
+	parser.Finalize()
+	if text, _ := parser.GetText(); text != "a &amp; b" {
+		t.Errorf("expected entities kept by default, got %q", text)
+	}
+}
+
+// TestNodesByName tests grouping nodes by element name
+func TestNodesByName(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append(`<read path="a"/> text <write path="b">1</write><read path="c"/><search q="x"/><read path="d">par`)
+
+	groups := parser.NodesByName()
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups, got %v", groups)
+	}
+	expected := map[string]string{"read": "a c d", "write": "b", "search": ""}
+	for name, want := range expected {
+		var paths []string
+		for _, node := range groups[name] {
+			if node.Name != name {
+				t.Errorf("expected only %s nodes in its group, got %s", name, node.Name)
+			}
+			if path, ok := node.Attributes["path"]; ok {
+				paths = append(paths, path)
+			}
+		}
+		if got := strings.Join(paths, " "); got != want {
+			t.Errorf("%s: expected paths %q in order, got %q", name, want, got)
+		}
+	}
+	if reads := groups["read"]; !reads[2].Partial || reads[0].Ordinal >= reads[1].Ordinal {
+		t.Errorf("expected the partial read last and ordinals increasing, got %+v", reads)
+	}
+
+	// The groups hold copies
+	groups["write"][0].Content = "changed"
+	if again := parser.NodesByName(); again["write"][0].Content != "1" {
+		t.Error("expected NodesByName to return copies")
+	}
+}
+
+// TestCDataSections tests CDATA sections inside and outside elements
+func TestCDataSections(t *testing.T) {
+	input := "Code: <write path=\"a.go\"><![CDATA[if a < b {\n\treturn \"<x>\" + \"&amp;\"\n}]]></write> done <![CDATA[<top>]]>"
+	for _, chunkSize := range []int{1, 3, 7, len(input)} {
+		config := DefaultConfig()
+		config.DecodeEntities = true
+		parser := NewStreamXmlParserWithConfig(config)
+		for i := 0; i < len(input); i += chunkSize {
+			parser.Append(input[i:min(i+chunkSize, len(input))])
+		}
+		parser.Finalize()
+
+		nodes, _ := parser.GetXmlNodes()
+		if len(nodes) != 1 || nodes[0].Name != "write" {
+			t.Fatalf("chunk %d: expected only the write node, got %+v", chunkSize, nodes)
+		}
+		if want := "if a < b {\n\treturn \"<x>\" + \"&amp;\"\n}"; nodes[0].Content != want {
+			t.Errorf("chunk %d: expected verbatim content %q, got %q", chunkSize, want, nodes[0].Content)
+		}
+		if !strings.HasPrefix(nodes[0].InnerXML(), "<![CDATA[") {
+			t.Errorf("chunk %d: expected InnerXML to keep the section, got %q", chunkSize, nodes[0].InnerXML())
+		}
+		var text strings.Builder
+		for _, node := range parser.GetAST() {
+			if node.Type == ASTNodeText {
+				text.WriteString(node.Text)
+			}
+		}
+		if got := text.String(); got != "Code:  done <top>" {
+			t.Errorf("chunk %d: unexpected text %q", chunkSize, got)
+		}
+	}
+}
+
+// TestState tests the parser state after each chunk
+func TestState(t *testing.T) {
+	parser := NewStreamXmlParser()
+	if state := parser.State(); state != StateText {
+		t.Fatalf("expected StateText initially, got %d", state)
+	}
+
+	steps := []struct {
+		chunk string
+		want  ParserState
+	}{
+		{"Let me check. ", StateText},
+		{"<", StateInTag},
+		{"tool name=\"a>b\"", StateInTag},
+		{">query ", StateInContent},
+		{"<b", StateInTag},
+		{">x</", StateInClosingTag},
+		{"b> <!-- <c> ", StateInContent},
+		{"--> more</to", StateInClosingTag},
+		{"ol>", StateText},
+		{" done <![CDATA[<x", StateText},
+		{">]]> <ping/>", StateText},
+		{"<a></a", StateInClosingTag},
+	}
+	for _, step := range steps {
+		parser.Append(step.chunk)
+		if state := parser.State(); state != step.want {
+			t.Errorf("after %q: expected state %d, got %d", step.chunk, step.want, state)
+		}
+	}
+}
+
+// TestAttributesWithoutSpaceAfterQuote tests attributes written right after a closing quote
+func TestAttributesWithoutSpaceAfterQuote(t *testing.T) {
+	tests := []struct {
+		input string
+		want  map[string]string
+	}{
+		{`<tool a="1"b="2">x</tool>`, map[string]string{"a": "1", "b": "2"}},
+		{`<tool a='1'b="2"c=3>x</tool>`, map[string]string{"a": "1", "b": "2", "c": "3"}},
+		{`<tool a="x y"b='>'/>`, map[string]string{"a": "x y", "b": ">"}},
+	}
+
+	for _, tt := range tests {
+		for _, size := range []int{1, 2, len(tt.input)} {
+			parser := NewStreamXmlParser()
+			for i := 0; i < len(tt.input); i += size {
+				parser.Append(tt.input[i:min(i+size, len(tt.input))])
+			}
+
+			node, _ := parser.GetXmlNode()
+			if node == nil || node.Partial || node.Name != "tool" {
+				t.Fatalf("%s (size %d): expected a complete tool node, got %+v", tt.input, size, node)
+			}
+			if fmt.Sprint(node.Attributes) != fmt.Sprint(tt.want) {
+				t.Errorf("%s (size %d): expected attributes %v, got %v", tt.input, size, tt.want, node.Attributes)
+			}
+			if warnings := parser.Warnings(); len(warnings) != 0 {
+				t.Errorf("%s (size %d): expected no warnings, got %v", tt.input, size, warnings)
+			}
+		}
+	}
+}
+
+// describeTree renders a node and its children as name[content]{children},
+// with * marking partial nodes
+func describeTree(node *XmlNode) string {
+	var out strings.Builder
+	out.WriteString(node.Name)
+	if node.Partial {
+		out.WriteString("*")
+	}
+	fmt.Fprintf(&out, "[%s]", node.Content)
+	if len(node.Children) > 0 {
+		out.WriteString("{")
+		for i, child := range node.Children {
+			if i > 0 {
+				out.WriteString(" ")
+			}
+			out.WriteString(describeTree(child))
+		}
+		out.WriteString("}")
+	}
+	return out.String()
+}
+
+// TestChildNodes tests building nested elements as child nodes
+func TestChildNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"two levels", `<tool name="write"><path>a.go</path> and <body>x</body></tool>`,
+			"tool[ and ]{path[a.go] body[x]}"},
+		{"three levels", `<call><args><arg k="1">one<sub/></arg><arg k="2">two</arg></args>!</call>`,
+			"call[!]{args[]{arg[one]{sub[]} arg[two]}}"},
+		{"partial inner", `<tool><args><arg>par`,
+			"tool*[]{args*[]{arg*[par]}}"},
+		{"partial after sibling", `<tool>a<x>1</x>b<y>2`,
+			"tool*[ab]{x[1] y*[2]}"},
+		{"unclosed child", `<expr>a<b && c>d</expr>`,
+			"expr[a]{b*[d]}"},
+	}
+
+	for _, tt := range tests {
+		for _, size := range []int{1, 4, len(tt.input)} {
+			config := DefaultConfig()
+			config.ChildNodes = true
+			parser := NewStreamXmlParserWithConfig(config)
+			for i := 0; i < len(tt.input); i += size {
+				parser.Append(tt.input[i:min(i+size, len(tt.input))])
+			}
+
+			nodes, _ := parser.GetXmlNodes()
+			if len(nodes) != 1 {
+				t.Fatalf("%s (size %d): expected 1 top-level node, got %d", tt.name, size, len(nodes))
+			}
+			if got := describeTree(nodes[0]); got != tt.expected {
+				t.Errorf("%s (size %d): expected tree %s, got %s", tt.name, size, tt.expected, got)
+			}
+		}
+	}
+
+	config := DefaultConfig()
+	config.ChildNodes = true
+	parser := NewStreamXmlParserWithConfig(config)
+	parser.Append(`<tool name="write"><path mode="w">a.go</path></tool>`)
+	node, _ := parser.GetXmlNode()
+	path := node.Children[0]
+	if path.Attributes["mode"] != "w" || path.Path() != "tool/path" {
+		t.Errorf("expected child attributes and path, got %v %q", path.Attributes, path.Path())
+	}
+	if node.InnerXML() != `<path mode="w">a.go</path>` {
+		t.Errorf("expected InnerXML to keep nested markup, got %q", node.InnerXML())
+	}
+}
+
+// TestSetNameRewriter tests rewriting element names before they are matched
+func TestSetNameRewriter(t *testing.T) {
+	canonical := func(name string) string {
+		name = strings.ToLower(strings.TrimPrefix(name, "ns:"))
+		if name == "invoke" || name == "function_call" {
+			return "tool"
+		}
+		return name
+	}
+
+	input := "a <ns:tool x=\"1\">one</TOOL> b <Invoke>two</ns:function_call> c <other>keep</other> <ns:TOOL/> <ns:tool"
+	for _, size := range []int{1, 5, len(input)} {
+		parser := NewStreamXmlParser()
+		parser.SetAllowedElements([]string{"tool"})
+		parser.SetNameRewriter(canonical)
+		for i := 0; i < len(input); i += size {
+			parser.Append(input[i:min(i+size, len(input))])
+		}
+
+		nodes, _ := parser.GetXmlNodes()
+		var got []string
+		for _, node := range nodes {
+			got = append(got, fmt.Sprintf("%s:%s:%v", node.Name, node.Content, node.Partial))
+		}
+		if want := "tool:one:false tool:two:false tool::false tool::true"; strings.Join(got, " ") != want {
+			t.Errorf("size %d: expected nodes %q, got %q", size, want, strings.Join(got, " "))
+		}
+		if text, _ := parser.GetText(); !strings.Contains(text, "<other>keep</other>") {
+			t.Errorf("size %d: expected disallowed elements to stay text, got %q", size, text)
+		}
+	}
+
+	// Without the rewriter the mismatched closing tag is not allowed, so the
+	// first element never closes
+	parser := NewStreamXmlParser()
+	parser.SetAllowedElements([]string{"tool"})
+	parser.Append("<tool>one</TOOL>")
+	if node, _ := parser.GetXmlNode(); !node.Partial {
+		t.Error("expected the element to stay open without a rewriter")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"errors"
+	"io"
+)
+
+// defaultReadChunkSize is the read size of ReadFrom when ParserConfig.ReadChunkSize is 0
+const defaultReadChunkSize = 4096
+
+var _ io.ReaderFrom = (*StreamXmlParser)(nil)
+
+// ReadFrom implements io.ReaderFrom: it reads r until io.EOF in chunks of
+// ParserConfig.ReadChunkSize bytes and appends each chunk as it arrives, so the
+// parser can be queried while reading continues. It returns the number of
+// bytes appended and the first error from r or Append, such as
+// ErrMaxBufferSizeExceeded; io.EOF is not an error. ReadFrom does not call
+// Finalize, so the caller can append more or finalize afterwards.
+// The parser lock is not held while waiting for r.
+func (p *StreamXmlParser) ReadFrom(r io.Reader) (int64, error) {
+	p.mu.RLock()
+	size := p.config.ReadChunkSize
+	p.mu.RUnlock()
+	if size == 0 {
+		size = defaultReadChunkSize
+	}
+
+	buf := make([]byte, size)
+	var total int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if appendErr := p.Append(string(buf[:n])); appendErr != nil {
+				return total, appendErr
+			}
+			total += int64(n)
+		}
+		if errors.Is(err, io.EOF) {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// TestReadFrom tests that reading from a reader matches appending the same input
+func TestReadFrom(t *testing.T) {
+	input := "Intro 世 <tool name=\"search\">query <b>x</b></tool> between <!-- c --> <ping a=1/> end <par"
+
+	// Top-level text arrives in one node per append, so adjacent text nodes
+	// are joined before comparing
+	describe := func(parser *StreamXmlParser) string {
+		var out strings.Builder
+		for _, node := range parser.GetAST() {
+			if node.Type == ASTNodeXml {
+				fmt.Fprintf(&out, "|%s %v|", node.XmlNode.Marshal(), node.XmlNode.Partial)
+			} else {
+				out.WriteString(node.Text)
+			}
+		}
+		return out.String()
+	}
+
+	whole := NewStreamXmlParser()
+	n, err := whole.ReadFrom(strings.NewReader(input))
+	if err != nil || n != int64(len(input)) {
+		t.Fatalf("expected %d bytes and no error, got %d, %v", len(input), n, err)
+	}
+
+	config := DefaultConfig()
+	config.ReadChunkSize = 2
+	bytewise := NewStreamXmlParserWithConfig(config)
+	n, err = bytewise.ReadFrom(iotest.OneByteReader(strings.NewReader(input)))
+	if err != nil || n != int64(len(input)) {
+		t.Fatalf("expected %d bytes and no error, got %d, %v", len(input), n, err)
+	}
+
+	// The parser stays usable after ReadFrom
+	whole.Finalize()
+	bytewise.Finalize()
+	if got, want := describe(bytewise), describe(whole); got != want {
+		t.Errorf("expected the same AST for both readers:\n%s\n%s", got, want)
+	}
+	if nodes, _ := whole.GetXmlNodes(); len(nodes) != 3 {
+		t.Errorf("expected 3 nodes, got %d", len(nodes))
+	}
+}
+
+// TestReadFromErrors tests the errors returned by ReadFrom
+func TestReadFromErrors(t *testing.T) {
+	failure := errors.New("connection reset")
+	parser := NewStreamXmlParser()
+	n, err := parser.ReadFrom(iotest.DataErrReader(iotest.TimeoutReader(strings.NewReader("<tool>abc</tool>"))))
+	if !errors.Is(err, iotest.ErrTimeout) || n != 16 {
+		t.Errorf("expected the reader's error after 16 bytes, got %d, %v", n, err)
+	}
+	if node, _ := parser.GetXmlNode(); node == nil || node.Partial {
+		t.Error("expected data read before the error to be parsed")
+	}
+	if _, err := parser.ReadFrom(iotest.ErrReader(failure)); !errors.Is(err, failure) {
+		t.Errorf("expected %v, got %v", failure, err)
+	}
+
+	config := DefaultConfig()
+	config.MaxBufferSize = 1024
+	parser = NewStreamXmlParserWithConfig(config)
+	n, err = parser.ReadFrom(strings.NewReader("<tool a=\"" + strings.Repeat("x", 5000)))
+	if !errors.Is(err, ErrMaxBufferSizeExceeded) {
+		t.Errorf("expected ErrMaxBufferSizeExceeded, got %v", err)
+	}
+	if n > 1024 {
+		t.Errorf("expected only bytes that fit to be counted, got %d", n)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ContentRule says whether an element declared in a Schema must have content
+type ContentRule int
+
+const (
+	// ContentAny accepts elements with or without content
+	ContentAny ContentRule = iota
+	// ContentRequired rejects elements whose content is empty or only whitespace
+	ContentRequired
+	// ContentForbidden rejects elements with content other than whitespace
+	ContentForbidden
+)
+
+// Schema declares the top-level elements a stream may contain, checked after
+// parsing with ValidateAgainst
+type Schema struct {
+	// Elements maps each allowed element name to its constraints
+	Elements map[string]ElementSchema
+}
+
+// ElementSchema holds the constraints for one element of a Schema
+type ElementSchema struct {
+	// RequiredAttributes lists attributes the element must have
+	RequiredAttributes []string
+
+	// Content says whether the element must or must not have content (default: ContentAny)
+	Content ContentRule
+}
+
+// ValidateAgainst checks the top-level XML nodes parsed so far against s and
+// returns every violation in document order, or nil if the document conforms.
+// Errors wrap ErrUnknownElement, ErrMissingRequiredAttribute,
+// ErrContentRequired or ErrContentForbidden. Unlike SetRequiredAttributes it
+// does not affect parsing and is meant to run once the stream is finalized;
+// partial nodes are checked as they currently stand.
+// This method is thread-safe.
+func (p *StreamXmlParser) ValidateAgainst(s Schema) []error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var errs []error
+	for _, node := range p.astNodes {
+		if node.Type != ASTNodeXml || node.XmlNode == nil {
+			continue
+		}
+		xmlNode := node.XmlNode
+		element, ok := s.Elements[xmlNode.Name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("<%s> at position %d: %w", xmlNode.Name, xmlNode.StartPos, ErrUnknownElement))
+			continue
+		}
+		for _, attr := range element.RequiredAttributes {
+			if _, ok := xmlNode.Attributes[attr]; !ok {
+				errs = append(errs, fmt.Errorf("<%s> at position %d requires attribute %q: %w", xmlNode.Name, xmlNode.StartPos, attr, ErrMissingRequiredAttribute))
+			}
+		}
+		hasContent := strings.TrimSpace(xmlNode.Content) != ""
+		switch {
+		case element.Content == ContentRequired && !hasContent:
+			errs = append(errs, fmt.Errorf("<%s> at position %d: %w", xmlNode.Name, xmlNode.StartPos, ErrContentRequired))
+		case element.Content == ContentForbidden && hasContent:
+			errs = append(errs, fmt.Errorf("<%s> at position %d: %w", xmlNode.Name, xmlNode.StartPos, ErrContentForbidden))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,80 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"errors"
+	"testing"
+)
+
+var toolSchema = Schema{
+	Elements: map[string]ElementSchema{
+		"tool":     {RequiredAttributes: []string{"name"}, Content: ContentRequired},
+		"thinking": {},
+		"done":     {Content: ContentForbidden},
+	},
+}
+
+// TestValidateAgainst tests schema validation of conforming and non-conforming documents
+func TestValidateAgainst(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []error
+	}{
+		{
+			name:  "conforming",
+			input: "Hi <thinking></thinking><tool name=\"search\">q</tool>\n<done/><done> </done>",
+		},
+		{
+			name:  "unknown element",
+			input: "<tool name=\"a\">q</tool><other>x</other>",
+			want:  []error{ErrUnknownElement},
+		},
+		{
+			name:  "missing attribute and content",
+			input: "<tool>  </tool>",
+			want:  []error{ErrMissingRequiredAttribute, ErrContentRequired},
+		},
+		{
+			name:  "forbidden content",
+			input: "<done>late</done><tool name=\"a\"/>",
+			want:  []error{ErrContentForbidden, ErrContentRequired},
+		},
+		{
+			name:  "partial node",
+			input: "<tool name=\"a\">",
+			want:  []error{ErrContentRequired},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewStreamXmlParser()
+			parser.Append(tt.input)
+			parser.Finalize()
+
+			errs := parser.ValidateAgainst(toolSchema)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("expected %d errors, got %v", len(tt.want), errs)
+			}
+			for i, err := range errs {
+				if !errors.Is(err, tt.want[i]) {
+					t.Errorf("error %d: expected %v, got %v", i, tt.want[i], err)
+				}
+			}
+		})
+	}
+}
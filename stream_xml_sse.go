@@ -0,0 +1,45 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import "strings"
+
+// sseDone is the data payload that marks the end of an OpenAI-style SSE stream
+const sseDone = "[DONE]"
+
+// AppendSSE appends the text delta carried by one line of a server-sent event
+// stream, such as an LLM API response where the text sits inside a JSON
+// payload. For "data:" lines, extract receives the payload without the field
+// name and the optional space after it, and returns the text to append, or
+// false if the payload carries none. Other lines (comments, event and id
+// fields, blank lines) and the "[DONE]" sentinel are ignored; call Finalize
+// when the stream ends. Errors are those of Append.
+// This method is thread-safe.
+func (p *StreamXmlParser) AppendSSE(line string, extract func(jsonLine string) (string, bool)) error {
+	line = strings.TrimRight(line, "\r\n")
+	payload, ok := strings.CutPrefix(line, "data:")
+	if !ok {
+		return nil
+	}
+	payload = strings.TrimPrefix(payload, " ")
+	if payload == sseDone {
+		return nil
+	}
+	text, ok := extract(payload)
+	if !ok || text == "" {
+		return nil
+	}
+	return p.Append(text)
+}
@@ -0,0 +1,72 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// extractDelta reads choices[0].delta.content from an OpenAI-style chunk
+func extractDelta(jsonLine string) (string, bool) {
+	var chunk struct {
+		Choices []struct {
+			Delta struct {
+				Content *string `json:"content"`
+			} `json:"delta"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal([]byte(jsonLine), &chunk); err != nil || len(chunk.Choices) == 0 {
+		return "", false
+	}
+	content := chunk.Choices[0].Delta.Content
+	if content == nil {
+		return "", false
+	}
+	return *content, true
+}
+
+// TestAppendSSE tests feeding SSE lines with comments, other fields and the done sentinel
+func TestAppendSSE(t *testing.T) {
+	lines := []string{
+		": keep-alive",
+		"event: message",
+		`data: {"choices":[{"delta":{"role":"assistant"}}]}`,
+		`data: {"choices":[{"delta":{"content":"Let me check. <tool na"}}]}` + "\r\n",
+		"",
+		`data:{"choices":[{"delta":{"content":"me=\"search\">q"}}]}`,
+		"id: 3",
+		": ping",
+		`data: {"choices":[{"delta":{"content":"uery</tool>"}}]}` + "\n",
+		"data: [DONE]",
+		"",
+	}
+
+	parser := NewStreamXmlParser()
+	for _, line := range lines {
+		if err := parser.AppendSSE(line, extractDelta); err != nil {
+			t.Fatalf("unexpected error for %q: %v", line, err)
+		}
+	}
+	parser.Finalize()
+
+	if text, _ := parser.GetText(); text != "Let me check. " {
+		t.Errorf("expected text %q, got %q", "Let me check. ", text)
+	}
+	nodes, _ := parser.GetXmlNodes()
+	if len(nodes) != 1 || nodes[0].Partial || nodes[0].Attributes["name"] != "search" || nodes[0].Content != "query" {
+		t.Errorf("expected the complete tool node, got %+v", nodes)
+	}
+}
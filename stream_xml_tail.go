@@ -0,0 +1,171 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"io"
+	"slices"
+	"sync"
+)
+
+// contentTail buffers the content of one element for a reader returned by
+// TailContent. The parser writes without blocking; Read blocks until data
+// arrives, the element ends or the reader is closed.
+type contentTail struct {
+	name string
+	node *XmlNode // the element being tailed, nil until it opens
+	sent int      // bytes of the node's content already written
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	done   bool
+	err    error // returned instead of io.EOF once data is read
+	closed bool
+}
+
+func newContentTail(name string) *contentTail {
+	tail := &contentTail{name: name}
+	tail.cond = sync.NewCond(&tail.mu)
+	return tail
+}
+
+// write queues content for the reader; it reports false once the reader is closed
+func (tail *contentTail) write(data string) bool {
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+	if tail.closed {
+		return false
+	}
+	if data != "" {
+		tail.data = append(tail.data, data...)
+		tail.cond.Broadcast()
+	}
+	return true
+}
+
+// finish makes Read return err, or io.EOF if err is nil, once the queued
+// content is read
+func (tail *contentTail) finish(err error) {
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+	tail.done = true
+	tail.err = err
+	tail.cond.Broadcast()
+}
+
+// Read implements io.Reader
+func (tail *contentTail) Read(b []byte) (int, error) {
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+	for len(tail.data) == 0 && !tail.done && !tail.closed {
+		tail.cond.Wait()
+	}
+	if tail.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if len(tail.data) == 0 {
+		if tail.err != nil {
+			return 0, tail.err
+		}
+		return 0, io.EOF
+	}
+	n := copy(b, tail.data)
+	tail.data = tail.data[n:]
+	return n, nil
+}
+
+// Close implements io.Closer; it stops delivery and unblocks a pending Read
+func (tail *contentTail) Close() error {
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+	tail.closed = true
+	tail.data = nil
+	tail.cond.Broadcast()
+	return nil
+}
+
+// TailContent returns a reader that streams the content of the top-level
+// element named name: the one currently open, or else the next to open. Read
+// blocks until more content is appended, possibly from another goroutine, and
+// returns io.EOF once the element closes, or at Finalize or Reset if it never
+// does. If Finalize fails, Read returns its error instead. Content of an
+// incomplete nested tag is only delivered once the tag resolves, and
+// SetContentUnwrap does not apply. Content is queued without bounds, so Append
+// never blocks on the reader; closing the reader stops delivery.
+// This method is thread-safe.
+func (p *StreamXmlParser) TailContent(name string) io.ReadCloser {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tail := newContentTail(name)
+	if p.finalized {
+		tail.finish(nil)
+		return tail
+	}
+	p.tails = append(p.tails, tail)
+	if p.depth > 0 && len(p.xmlStack) > 0 && p.xmlStack[0].Name == name {
+		tail.node = p.xmlStack[0]
+		p.feedTails(tail.node, false)
+	}
+	return tail
+}
+
+// bindTails attaches waiting tails to a top-level element that just opened
+func (p *StreamXmlParser) bindTails(xmlNode *XmlNode) {
+	for _, tail := range p.tails {
+		if tail.node == nil && tail.name == xmlNode.Name {
+			tail.node = xmlNode
+		}
+	}
+}
+
+// feedTails writes new content of a top-level element to its tails. Until the
+// element is complete, provisional content is held back. Once complete, the
+// tails end and are removed.
+func (p *StreamXmlParser) feedTails(xmlNode *XmlNode, complete bool) {
+	if len(p.tails) == 0 {
+		return
+	}
+	stable := len(xmlNode.Content)
+	if !complete && p.xmlStack[len(p.xmlStack)-1] == xmlNode {
+		stable -= p.provisionalContent
+	}
+	p.tails = slices.DeleteFunc(p.tails, func(tail *contentTail) bool {
+		if tail.node != xmlNode {
+			return false
+		}
+		if stable > tail.sent {
+			if !tail.write(xmlNode.Content[tail.sent:stable]) {
+				return true
+			}
+			tail.sent = stable
+		}
+		if complete {
+			tail.finish(nil)
+		}
+		return complete
+	})
+}
+
+// finishTails ends all tails, e.g. at end of stream; their readers get err
+// after the queued content, or io.EOF if err is nil
+func (p *StreamXmlParser) finishTails(err error) {
+	for _, tail := range p.tails {
+		tail.finish(err)
+	}
+	clear(p.tails)
+	p.tails = p.tails[:0]
+}
@@ -0,0 +1,141 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestTailContent tests reading an element's content while it streams
+func TestTailContent(t *testing.T) {
+	parser := NewStreamXmlParser()
+	reader := parser.TailContent("write")
+
+	type result struct {
+		data string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		data, err := io.ReadAll(reader)
+		results <- result{string(data), err}
+	}()
+
+	input := `Intro <read>skip</read> <write path="a.go">package main <b` + "\n" + `r/>func main() {}</write> after`
+	for i := 0; i < len(input); i += 3 {
+		parser.Append(input[i:min(i+3, len(input))])
+	}
+
+	got := <-results
+	if got.err != nil {
+		t.Fatalf("expected a clean EOF, got %v", got.err)
+	}
+	node, _ := parser.GetXmlNodes()
+	if want := node[1].Content; got.data != want {
+		t.Errorf("expected %q, got %q", want, got.data)
+	}
+	reader.Close()
+}
+
+// TestTailContentAlreadyOpen tests tailing an element that is already open
+func TestTailContentAlreadyOpen(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("<write>first ")
+	reader := parser.TailContent("write")
+	parser.Append("second</write>")
+
+	data, err := io.ReadAll(reader)
+	if err != nil || string(data) != "first second" {
+		t.Errorf("expected the whole content and EOF, got %q, %v", data, err)
+	}
+}
+
+// TestTailContentEnds tests that reads end when the element or stream ends
+func TestTailContentEnds(t *testing.T) {
+	// An element that never closes ends at Finalize
+	parser := NewStreamXmlParser()
+	reader := parser.TailContent("write")
+	parser.Append("<write>partial")
+	parser.Finalize()
+	if data, err := io.ReadAll(reader); err != nil || string(data) != "partial" {
+		t.Errorf("expected partial content and EOF at Finalize, got %q, %v", data, err)
+	}
+
+	// Tailing after Finalize returns EOF at once
+	if data, err := io.ReadAll(parser.TailContent("write")); err != nil || len(data) != 0 {
+		t.Errorf("expected immediate EOF after Finalize, got %q, %v", data, err)
+	}
+
+	// A self-closing element has no content
+	parser = NewStreamXmlParser()
+	reader = parser.TailContent("write")
+	parser.Append("<write/>")
+	if data, err := io.ReadAll(reader); err != nil || len(data) != 0 {
+		t.Errorf("expected empty content for a self-closing element, got %q, %v", data, err)
+	}
+}
+
+// TestTailContentClose tests closing a reader before the element ends
+func TestTailContentClose(t *testing.T) {
+	parser := NewStreamXmlParser()
+	reader := parser.TailContent("write")
+	parser.Append("<write>abc")
+
+	buf := make([]byte, 2)
+	if n, err := reader.Read(buf); err != nil || string(buf[:n]) != "ab" {
+		t.Fatalf("expected to read 'ab', got %q, %v", buf[:n], err)
+	}
+
+	// A blocked Read is released by Close
+	errs := make(chan error, 1)
+	go func() {
+		reader.Read(buf)
+		_, err := reader.Read(buf)
+		errs <- err
+	}()
+	reader.Close()
+	if err := <-errs; !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("expected io.ErrClosedPipe after Close, got %v", err)
+	}
+
+	parser.Append("def")
+	if len(parser.tails) != 0 {
+		t.Errorf("expected the closed reader to be dropped, got %d tails", len(parser.tails))
+	}
+}
+
+// TestTailContentFinalizeError tests that a failed Finalize ends the reader with its error
+func TestTailContentFinalizeError(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxDepth = 2
+	parser := NewStreamXmlParserWithConfig(config)
+	reader := parser.TailContent("write")
+	parser.Append("<write>abc ")
+
+	parser.Pause()
+	parser.Append("<a><b>x")
+	finalizeErr := parser.Finalize()
+	if !errors.Is(finalizeErr, ErrMaxDepthExceeded) {
+		t.Fatalf("expected ErrMaxDepthExceeded, got %v", finalizeErr)
+	}
+
+	data, err := io.ReadAll(reader)
+	if !errors.Is(err, ErrMaxDepthExceeded) || !strings.HasPrefix(string(data), "abc ") {
+		t.Errorf("expected the content so far and the Finalize error, got %q, %v", data, err)
+	}
+}
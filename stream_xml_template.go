@@ -0,0 +1,45 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"html/template"
+)
+
+// TemplateFuncs returns template functions exposing the parser output:
+//
+//	xmlNodes          all XML nodes (complete and partial)
+//	xmlText           all accumulated text
+//	nodeAttr name key the attribute key of the first node named name
+//
+// The functions read the parser state at template execution time.
+func (p *StreamXmlParser) TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"xmlNodes": p.GetXmlNodes,
+		"xmlText":  p.GetText,
+		"nodeAttr": func(name string, key string) (string, error) {
+			nodes, err := p.GetXmlNodes()
+			if err != nil {
+				return "", err
+			}
+			for _, node := range nodes {
+				if node.Name == name {
+					return node.Attributes[key], nil
+				}
+			}
+			return "", nil
+		},
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2025 EasyAgent
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streamxml
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+)
+
+// TestTemplateFuncs tests rendering parser output through html/template
+func TestTemplateFuncs(t *testing.T) {
+	parser := NewStreamXmlParser()
+	parser.Append("Let me <b>search</b>. ")
+	parser.Append("<tool name=\"search\">query</tool>")
+	parser.Append("<tool name=\"read\">file.txt</tool>")
+
+	tmpl, err := template.New("out").Funcs(parser.TemplateFuncs()).Parse(
+		`{{range xmlNodes}}[{{.Name}}:{{index .Attributes "name"}}={{.Content}}]{{end}}` +
+			`|{{nodeAttr "tool" "name"}}|{{nodeAttr "missing" "name"}}|{{xmlText}}`)
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, nil); err != nil {
+		t.Fatalf("failed to execute template: %v", err)
+	}
+
+	expected := "[b:=search][tool:search=query][tool:read=file.txt]|search||Let me . "
+	if out.String() != expected {
+		t.Errorf("expected '%s', got '%s'", expected, out.String())
+	}
+}
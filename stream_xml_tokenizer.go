@@ -15,8 +15,10 @@
 package streamxml
 
 import (
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type TokenType int
@@ -31,20 +33,54 @@ const (
 	TokenEquals                   // =
 	TokenAttributeValue           // attribute value
 	TokenIncomplete               // incomplete token
+	TokenComment                  // <!-- comment -->
+	TokenCData                    // <![CDATA[ verbatim text ]]>
 )
 
+var tokenTypeNames = [...]string{
+	TokenText:           "Text",
+	TokenOpenBracket:    "OpenBracket",
+	TokenCloseBracket:   "CloseBracket",
+	TokenSlash:          "Slash",
+	TokenElementName:    "ElementName",
+	TokenAttributeName:  "AttributeName",
+	TokenEquals:         "Equals",
+	TokenAttributeValue: "AttributeValue",
+	TokenIncomplete:     "Incomplete",
+	TokenComment:        "Comment",
+	TokenCData:          "CData",
+}
+
+// String returns the name of the token type, e.g. "ElementName"
+func (tt TokenType) String() string {
+	if tt >= 0 && int(tt) < len(tokenTypeNames) {
+		return tokenTypeNames[tt]
+	}
+	return "TokenType(" + strconv.Itoa(int(tt)) + ")"
+}
+
 type Token struct {
 	Type     TokenType
 	Start    int
 	End      int
 	Complete bool
+
+	// base is the number of bytes discarded by buffer cleanup when the
+	// token was returned, so Value can detect positions that went stale
+	base int
+
+	// textified is set on text tokens that hold a tag kept as text
+	textified TextifyReason
 }
 
 type StreamXmlTokenizer struct {
 	buffer                 string
+	bufferBuilder          strings.Builder // backs buffer so appends are amortized O(len(data))
 	position               int
 	allowedElements        map[string]bool
+	nameRewriter           func(name string) string // see StreamXmlParser.SetNameRewriter
 	consumed               int
+	discarded              int // bytes removed from the front of the stream by cleanupBuffer
 	bufferCleanupThreshold int
 	maxBufferSize          int
 
@@ -55,12 +91,34 @@ type StreamXmlTokenizer struct {
 	textBuffer   strings.Builder
 	textStartPos int
 
+	// Quote tracking inside a tag so '>' within attribute values does not end it
+	tagQuote        byte
+	tagLastNonSpace byte
+
 	// Pending tokens from a tag being parsed
 	pendingTokens []*Token
 	pendingIndex  int
 
 	// Track if incomplete token was already returned
 	incompleteReturned bool
+
+	// Doubled bracket escapes (<< and >>) in text
+	doubledBracketEscape bool
+	skipGreater          bool
+
+	// Text mode disables tag recognition (see SetTextMode)
+	textMode bool
+
+	// No more data will be appended (see Close)
+	closed bool
+
+	// Markdown code fence tracking (see ParserConfig.RespectCodeFences)
+	codeFences  bool
+	inFence     bool
+	fenceLen    int  // backticks of the opening fence
+	fenceRun    int  // backticks seen so far at the start of the current line
+	atLineStart bool // only indentation seen since the last newline
+	lineIndent  int
 }
 
 func NewStreamXmlTokenizer() *StreamXmlTokenizer {
@@ -77,6 +135,9 @@ func NewStreamXmlTokenizerWithConfig(config ParserConfig) *StreamXmlTokenizer {
 		consumed:               0,
 		bufferCleanupThreshold: config.BufferCleanupThreshold,
 		maxBufferSize:          config.MaxBufferSize,
+		doubledBracketEscape:   config.DoubledBracketEscape,
+		codeFences:             config.RespectCodeFences,
+		atLineStart:            true,
 		pendingTokens:          make([]*Token, 0),
 		pendingIndex:           0,
 	}
@@ -99,14 +160,30 @@ func (t *StreamXmlTokenizer) SetAllowedElements(elements []string) {
 	}
 }
 
-// Append adds more data to the tokenizer
+// rewriteName applies the name rewriter, if any, to an element name
+func (t *StreamXmlTokenizer) rewriteName(name string) string {
+	if t.nameRewriter == nil || name == "" {
+		return name
+	}
+	return t.nameRewriter(name)
+}
+
+// Append adds more data to the tokenizer. It returns ErrMaxBufferSizeExceeded
+// without changing any state if the buffer would grow beyond MaxBufferSize,
+// not counting data that buffer cleanup can discard.
 func (t *StreamXmlTokenizer) Append(data string) error {
 	// Check buffer size limit
-	if len(t.buffer)+len(data) > t.maxBufferSize {
+	cut := t.reclaimable()
+	if len(t.buffer)-cut+len(data) > t.maxBufferSize {
 		return ErrMaxBufferSizeExceeded
 	}
+	if len(t.buffer)+len(data) > t.maxBufferSize {
+		// Fits only once consumed data is discarded, even below the threshold
+		t.compact(cut)
+	}
 
-	t.buffer += data
+	t.bufferBuilder.WriteString(data)
+	t.buffer = t.bufferBuilder.String()
 	// Reset incomplete flag when new data arrives
 	t.incompleteReturned = false
 
@@ -116,14 +193,134 @@ func (t *StreamXmlTokenizer) Append(data string) error {
 	return nil
 }
 
-// GetBuffer returns the current buffer for value extraction
+// Close marks the end of the stream; no data may be appended afterwards.
+// Text at the end of the buffer is only reported with Complete set once the
+// stream is closed, since until then more text may follow.
+func (t *StreamXmlTokenizer) Close() {
+	t.closed = true
+}
+
+// SetTextMode turns tag recognition off or on. While on, all data is text,
+// including '<'. A tag that is still incomplete when text mode is turned on
+// is kept as text.
+func (t *StreamXmlTokenizer) SetTextMode(on bool) {
+	if on && t.inTag {
+		t.inTag = false
+		t.textStartPos = t.tagStartPos
+		t.textBuffer.Reset()
+		t.textBuffer.WriteString(t.tagBuffer.String())
+		t.tagBuffer.Reset()
+		t.incompleteReturned = false
+	}
+	t.textMode = on
+}
+
+// GetBuffer returns the current buffer for value extraction. To read it from
+// other goroutines, use Snapshot instead.
 func (t *StreamXmlTokenizer) GetBuffer() string {
 	return t.buffer
 }
 
+// PendingTokens returns a copy of the tokens of an already parsed tag that
+// NextToken has not returned yet. It does not consume anything.
+func (t *StreamXmlTokenizer) PendingTokens() []Token {
+	tokens := make([]Token, 0, len(t.pendingTokens)-t.pendingIndex)
+	for _, token := range t.pendingTokens[t.pendingIndex:] {
+		resolved := *token
+		resolved.base = t.discarded
+		tokens = append(tokens, resolved)
+	}
+	return tokens
+}
+
+// ResolvedToken is a token together with its value from the buffer
+type ResolvedToken struct {
+	Type     TokenType
+	Value    string
+	Complete bool
+}
+
+// Dump drains all currently available tokens and resolves their values.
+// It is meant for readable snapshots in tests and debugging.
+func (t *StreamXmlTokenizer) Dump() []ResolvedToken {
+	var tokens []ResolvedToken
+	for token := t.NextToken(); token != nil; token = t.NextToken() {
+		tokens = append(tokens, ResolvedToken{
+			Type:     token.Type,
+			Value:    t.buffer[token.Start:token.End],
+			Complete: token.Complete,
+		})
+	}
+	return tokens
+}
+
 // NextToken returns the next token from the buffer.
 // Returns nil if no complete token is available yet.
 func (t *StreamXmlTokenizer) NextToken() *Token {
+	token := t.nextToken()
+	if token != nil {
+		token.base = t.discarded
+		if token.Type == TokenText {
+			// Returned text is no longer needed for tokenizing, so long runs
+			// of text can be discarded without waiting for a tag to complete
+			t.consumed = max(t.consumed, token.End)
+		}
+	}
+	return token
+}
+
+// NextTokenValue returns the next token like NextToken together with its
+// text, resolved against the current buffer. The value is empty if there is
+// no token.
+func (t *StreamXmlTokenizer) NextTokenValue() (*Token, string) {
+	token := t.NextToken()
+	if token == nil {
+		return nil, ""
+	}
+	value, _ := t.Value(*token)
+	return token, value
+}
+
+// Value returns the text of a token returned by this tokenizer. It reports
+// false if the token lies outside the current buffer, e.g. because buffer
+// cleanup has since discarded it.
+func (t *StreamXmlTokenizer) Value(tok Token) (string, bool) {
+	return t.Snapshot().Value(tok)
+}
+
+// BufferView is an immutable snapshot of the tokenizer buffer. Unlike the
+// tokenizer, it is safe for concurrent use, so tokens can be resolved from
+// several goroutines while the tokenizer keeps receiving data.
+type BufferView struct {
+	buffer    string
+	discarded int
+}
+
+// Snapshot returns a view of the current buffer. Like the other tokenizer
+// methods it must not be called concurrently with Append or NextToken; the
+// returned view can then be shared freely.
+func (t *StreamXmlTokenizer) Snapshot() BufferView {
+	return BufferView{buffer: t.buffer, discarded: t.discarded}
+}
+
+// Buffer returns the buffer contents at the time of the snapshot
+func (v BufferView) Buffer() string {
+	return v.buffer
+}
+
+// Value returns the text of a token returned by the tokenizer up to the time
+// of the snapshot. It reports false if the token lies outside the snapshot,
+// e.g. because buffer cleanup had discarded it or it was returned later.
+func (v BufferView) Value(tok Token) (string, bool) {
+	start := tok.Start + tok.base - v.discarded
+	end := tok.End + tok.base - v.discarded
+	if start < 0 || end < start || end > len(v.buffer) {
+		return "", false
+	}
+	return v.buffer[start:end], true
+}
+
+func (t *StreamXmlTokenizer) nextToken() *Token {
 	// First return any pending tokens
 	if t.pendingIndex < len(t.pendingTokens) {
 		token := t.pendingTokens[t.pendingIndex]
@@ -159,10 +356,15 @@ func (t *StreamXmlTokenizer) NextToken() *Token {
 				break
 			}
 		} else {
+			before := t.position
 			token := t.processText()
 			if token != nil {
 				return token
 			}
+			if !t.inTag && t.position == before {
+				// Waiting for more data to disambiguate the next byte
+				break
+			}
 		}
 	}
 
@@ -172,20 +374,21 @@ func (t *StreamXmlTokenizer) NextToken() *Token {
 			Type:     TokenText,
 			Start:    t.textStartPos,
 			End:      t.position,
-			Complete: true, // Text at end of buffer is complete
+			Complete: t.closed, // More text may follow until the stream is closed
 		}
 		// Reset the text buffer to avoid returning the same token repeatedly
 		t.textBuffer.Reset()
 		return token
 	}
 
-	// Return incomplete tag if any
-	if t.inTag && t.tagBuffer.Len() > 0 && !t.incompleteReturned {
+	// Return incomplete tag if any (comments and CDATA sections are never
+	// reported as partial tags)
+	if t.inTag && t.tagBuffer.Len() > 0 && !t.incompleteReturned && !isSectionPrefix(t.tagBuffer.String()) {
 		t.incompleteReturned = true
 		return &Token{
 			Type:     TokenIncomplete,
 			Start:    t.tagStartPos,
-			End:      t.position,
+			End:      fullRunesEnd(t.buffer, t.tagStartPos, t.position),
 			Complete: false,
 		}
 	}
@@ -197,7 +400,25 @@ func (t *StreamXmlTokenizer) processText() *Token {
 	for t.position < len(t.buffer) {
 		ch := t.buffer[t.position]
 
-		if ch == '<' {
+		if t.codeFences {
+			t.trackFence(ch)
+		}
+		raw := t.textMode || t.inFence
+
+		if t.doubledBracketEscape && !raw {
+			if token, handled := t.processDoubledBracket(ch); handled {
+				if token != nil {
+					return token
+				}
+				continue
+			} else if ch == '<' && t.position+1 >= len(t.buffer) && !t.closed {
+				// Cannot tell "<<" from a tag start until more data arrives; once
+				// closed, a final '<' is handled like any other
+				break
+			}
+		}
+
+		if ch == '<' && !raw {
 			// Found start of potential XML tag
 			var token *Token
 			if t.textBuffer.Len() > 0 {
@@ -215,6 +436,8 @@ func (t *StreamXmlTokenizer) processText() *Token {
 			t.inTag = true
 			t.tagStartPos = t.position
 			t.tagBuffer.Reset()
+			t.tagQuote = 0
+			t.tagLastNonSpace = 0
 
 			if token != nil {
 				return token
@@ -223,6 +446,11 @@ func (t *StreamXmlTokenizer) processText() *Token {
 			// Continue to try completing the tag
 			break
 		} else {
+			if ch >= utf8.RuneSelf && !utf8.FullRuneInString(t.buffer[t.position:]) {
+				// Wait for the rest of a multibyte sequence split across appends
+				break
+			}
+
 			// Accumulate text
 			if t.textBuffer.Len() == 0 {
 				t.textStartPos = t.position
@@ -235,6 +463,86 @@ func (t *StreamXmlTokenizer) processText() *Token {
 	return nil
 }
 
+// trackFence follows markdown code fences (``` at the start of a line) in text.
+// It may see the same byte again when processText waits for more data, so
+// every step is idempotent for bytes other than '`', which are always consumed.
+func (t *StreamXmlTokenizer) trackFence(ch byte) {
+	if ch != '`' && t.fenceRun > 0 {
+		// The backtick run ended, see whether it was a fence marker
+		if t.fenceRun >= 3 {
+			if !t.inFence {
+				t.inFence = true
+				t.fenceLen = t.fenceRun
+			} else if t.fenceRun >= t.fenceLen {
+				t.inFence = false
+			}
+		}
+		t.fenceRun = 0
+	}
+
+	switch {
+	case ch == '`' && (t.fenceRun > 0 || t.atLineStart):
+		t.fenceRun++
+		t.atLineStart = false
+	case ch == '\n':
+		t.atLineStart = true
+		t.lineIndent = 0
+	case ch == ' ' && t.atLineStart && t.lineIndent < 3:
+		t.lineIndent++
+	default:
+		t.atLineStart = false
+	}
+}
+
+// processDoubledBracket handles the << and >> escapes in text. It reports whether
+// the byte at the current position was consumed, along with any text token to return.
+func (t *StreamXmlTokenizer) processDoubledBracket(ch byte) (*Token, bool) {
+	if ch != '>' {
+		t.skipGreater = false
+	}
+
+	switch {
+	case ch == '>' && t.skipGreater:
+		// Second half of ">>", drop it
+		t.skipGreater = false
+		token := t.flushText()
+		t.position++
+		return token, true
+
+	case ch == '>':
+		// Literal '>', a following '>' completes the escape
+		t.skipGreater = true
+		return nil, false
+
+	case ch == '<' && t.position+1 < len(t.buffer) && t.buffer[t.position+1] == '<':
+		// "<<" is a literal '<': keep the first byte as text and drop the second
+		if t.textBuffer.Len() == 0 {
+			t.textStartPos = t.position
+		}
+		t.textBuffer.WriteByte(ch)
+		t.position++
+		token := t.flushText()
+		t.position++
+		return token, true
+	}
+	return nil, false
+}
+
+// flushText returns the accumulated text as a complete token, or nil if there is none
+func (t *StreamXmlTokenizer) flushText() *Token {
+	if t.textBuffer.Len() == 0 {
+		return nil
+	}
+	token := &Token{
+		Type:     TokenText,
+		Start:    t.textStartPos,
+		End:      t.position,
+		Complete: true,
+	}
+	t.textBuffer.Reset()
+	return token
+}
+
 func (t *StreamXmlTokenizer) tryCompleteTag() bool {
 	// Try to parse the tag to completion
 	// Look for the closing >
@@ -243,10 +551,69 @@ func (t *StreamXmlTokenizer) tryCompleteTag() bool {
 		t.tagBuffer.WriteByte(ch)
 		t.position++
 
+		if t.tagQuote != 0 {
+			// Inside a quoted attribute value, only the matching quote is significant
+			if ch == t.tagQuote {
+				t.tagQuote = 0
+				t.tagLastNonSpace = ch
+			}
+			continue
+		}
+
+		if (ch == '"' || ch == '\'') && t.tagLastNonSpace == '=' && !isSectionPrefix(t.tagBuffer.String()) {
+			t.tagQuote = ch
+			continue
+		}
+		if utf8.RuneStart(ch) && !isSpaceAt(t.buffer, t.position-1) {
+			t.tagLastNonSpace = ch
+		}
+
+		if ch == '<' && t.tagBuffer.Len() > 1 && !isSectionPrefix(t.tagBuffer.String()) {
+			// A new '<' before '>' means the earlier one did not start a tag:
+			// emit it as text and restart the tag here
+			t.pendingTokens = append(t.pendingTokens, &Token{
+				Type:      TokenText,
+				Start:     t.tagStartPos,
+				End:       t.position - 1,
+				Complete:  true,
+				textified: TextifyMalformed,
+			})
+			t.tagStartPos = t.position - 1
+			t.tagBuffer.Reset()
+			t.tagBuffer.WriteByte(ch)
+			t.tagLastNonSpace = 0
+			t.incompleteReturned = false
+			return true
+		}
+
 		if ch == '>' {
-			// Tag is complete, parse it
 			tagContent := t.tagBuffer.String()
-			t.parseAndEmitTag(tagContent)
+			if strings.HasPrefix(tagContent, cdataStart) {
+				// CDATA sections only end at ]]>, everything before is verbatim
+				if len(tagContent) < len(cdataStart)+len(cdataEnd) || !strings.HasSuffix(tagContent, cdataEnd) {
+					continue
+				}
+				t.pendingTokens = append(t.pendingTokens, &Token{
+					Type:     TokenCData,
+					Start:    t.tagStartPos,
+					End:      t.position,
+					Complete: true,
+				})
+			} else if strings.HasPrefix(tagContent, commentStart) {
+				// Comments only end at -->
+				if len(tagContent) < len(commentStart)+len(commentEnd) || !strings.HasSuffix(tagContent, commentEnd) {
+					continue
+				}
+				t.pendingTokens = append(t.pendingTokens, &Token{
+					Type:     TokenComment,
+					Start:    t.tagStartPos,
+					End:      t.position,
+					Complete: true,
+				})
+			} else {
+				// Tag is complete, parse it
+				t.parseAndEmitTag(tagContent)
+			}
 
 			t.inTag = false
 			t.tagBuffer.Reset()
@@ -260,30 +627,139 @@ func (t *StreamXmlTokenizer) tryCompleteTag() bool {
 	return false
 }
 
-// cleanupBuffer removes consumed data from buffer to prevent memory growth
-func (t *StreamXmlTokenizer) cleanupBuffer() {
-	if t.consumed >= t.bufferCleanupThreshold {
-		// Remove consumed portion of buffer
-		t.buffer = t.buffer[t.consumed:]
-
-		// Adjust all position offsets
-		t.position -= t.consumed
-		if t.tagStartPos >= t.consumed {
-			t.tagStartPos -= t.consumed
+const (
+	commentStart = "<!--"
+	commentEnd   = "-->"
+	cdataStart   = "<![CDATA["
+	cdataEnd     = "]]>"
+)
+
+// isCommentPrefix reports whether an unfinished tag is, or may still become, a comment
+func isCommentPrefix(tag string) bool {
+	if len(tag) < len(commentStart) {
+		// A lone "<" is an ordinary tag start
+		return len(tag) > 1 && strings.HasPrefix(commentStart, tag)
+	}
+	return strings.HasPrefix(tag, commentStart)
+}
+
+// isCDataPrefix reports whether an unfinished tag is, or may still become, a CDATA section
+func isCDataPrefix(tag string) bool {
+	if len(tag) < len(cdataStart) {
+		return len(tag) > 1 && strings.HasPrefix(cdataStart, tag)
+	}
+	return strings.HasPrefix(tag, cdataStart)
+}
+
+// isSectionPrefix reports whether an unfinished tag is, or may still become,
+// a comment or CDATA section, inside which quotes and '<' have no meaning
+func isSectionPrefix(tag string) bool {
+	return isCommentPrefix(tag) || isCDataPrefix(tag)
+}
+
+// looksLikeAttribute reports whether s starts with an unquoted name=... pair
+func looksLikeAttribute(s string) bool {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch == '=' {
+			return i > 0
 		}
-		if t.textStartPos >= t.consumed {
-			t.textStartPos -= t.consumed
+		if ch == '"' || ch == '\'' || isSpaceAt(s, i) {
+			return false
 		}
+	}
+	return false
+}
 
-		// Adjust pending token positions
-		for i := range t.pendingTokens {
-			t.pendingTokens[i].Start -= t.consumed
-			t.pendingTokens[i].End -= t.consumed
+// isSpaceAt reports whether the rune starting at byte offset i of s is whitespace.
+// Continuation bytes of a multibyte rune are never whitespace.
+func isSpaceAt(s string, i int) bool {
+	if s[i] < utf8.RuneSelf {
+		return unicode.IsSpace(rune(s[i]))
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsSpace(r)
+}
+
+// skipSpace returns the offset of the first non-whitespace rune of s at or after i
+func skipSpace(s string, i int) int {
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !unicode.IsSpace(r) {
+			break
 		}
+		i += size
+	}
+	return i
+}
 
-		// Reset consumed counter
-		t.consumed = 0
+// fullRunesEnd returns end, moved back before a trailing incomplete UTF-8
+// sequence in s[start:end] if there is one
+func fullRunesEnd(s string, start, end int) int {
+	for i := end - 1; i >= start && i >= end-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			if !utf8.FullRuneInString(s[i:end]) {
+				return i
+			}
+			break
+		}
 	}
+	return end
+}
+
+// inUnterminatedQuote reports whether the pending tag has an attribute quote that is still open
+func (t *StreamXmlTokenizer) inUnterminatedQuote() bool {
+	return t.inTag && t.tagQuote != 0
+}
+
+// cleanupBuffer removes consumed data from buffer to prevent memory growth
+func (t *StreamXmlTokenizer) cleanupBuffer() {
+	if cut := t.reclaimable(); cut >= t.bufferCleanupThreshold {
+		t.compact(cut)
+	}
+}
+
+// reclaimable returns the length of the buffer prefix that can be discarded.
+// Data still referenced by pending tokens is kept so their values stay resolvable.
+func (t *StreamXmlTokenizer) reclaimable() int {
+	cut := t.consumed
+	for i := t.pendingIndex; i < len(t.pendingTokens); i++ {
+		if t.pendingTokens[i].Start < cut {
+			cut = t.pendingTokens[i].Start
+		}
+	}
+	return cut
+}
+
+// compact discards the first cut bytes of the buffer and shifts all positions
+func (t *StreamXmlTokenizer) compact(cut int) {
+	if cut <= 0 {
+		return
+	}
+
+	// Remove consumed portion of buffer
+	remaining := t.buffer[cut:]
+	t.bufferBuilder = strings.Builder{}
+	t.bufferBuilder.WriteString(remaining)
+	t.buffer = t.bufferBuilder.String()
+
+	// Adjust all position offsets
+	t.position -= cut
+	if t.tagStartPos >= cut {
+		t.tagStartPos -= cut
+	}
+	if t.textStartPos >= cut {
+		t.textStartPos -= cut
+	}
+
+	// Adjust pending token positions
+	for i := t.pendingIndex; i < len(t.pendingTokens); i++ {
+		t.pendingTokens[i].Start -= cut
+		t.pendingTokens[i].End -= cut
+	}
+
+	t.consumed -= cut
+	t.discarded += cut
 }
 
 func (t *StreamXmlTokenizer) parseAndEmitTag(tagContent string) {
@@ -291,10 +767,11 @@ func (t *StreamXmlTokenizer) parseAndEmitTag(tagContent string) {
 	if len(tagContent) < 2 {
 		// Invalid tag, treat as text
 		t.pendingTokens = append(t.pendingTokens, &Token{
-			Type:     TokenText,
-			Start:    t.tagStartPos,
-			End:      t.tagStartPos + len(tagContent),
-			Complete: true,
+			Type:      TokenText,
+			Start:     t.tagStartPos,
+			End:       t.tagStartPos + len(tagContent),
+			Complete:  true,
+			textified: TextifyMalformed,
 		})
 		return
 	}
@@ -314,10 +791,19 @@ func (t *StreamXmlTokenizer) parseAndEmitTag(tagContent string) {
 		inner = inner[:len(inner)-1]
 	}
 
-	// Extract element name
-	inner = strings.TrimSpace(inner)
+	// Extract element name, keeping track of where it starts in the tag so
+	// token offsets stay exact whatever whitespace (tabs, newlines, several
+	// spaces) surrounds it
+	innerStart := t.tagStartPos + 1 // Skip <
+	if isClosing {
+		innerStart++ // Skip /
+	}
+	trimmed := strings.TrimLeftFunc(inner, unicode.IsSpace)
+	innerStart += len(inner) - len(trimmed)
+	inner = strings.TrimRightFunc(trimmed, unicode.IsSpace)
 	elementName := ""
 	restOfTag := ""
+	restStart := 0
 
 	// Find where element name ends (space or end of string)
 	spaceIdx := -1
@@ -330,19 +816,21 @@ func (t *StreamXmlTokenizer) parseAndEmitTag(tagContent string) {
 
 	if spaceIdx >= 0 {
 		elementName = inner[:spaceIdx]
-		restOfTag = strings.TrimSpace(inner[spaceIdx+1:])
+		restStart = skipSpace(inner, spaceIdx)
+		restOfTag = inner[restStart:]
 	} else {
 		elementName = inner
 	}
 
 	// Check if element is allowed
-	if t.allowedElements != nil && !t.allowedElements[elementName] {
+	if t.allowedElements != nil && !t.allowedElements[t.rewriteName(elementName)] {
 		// Not in allowed list, treat entire tag as text
 		t.pendingTokens = append(t.pendingTokens, &Token{
-			Type:     TokenText,
-			Start:    t.tagStartPos,
-			End:      t.tagStartPos + len(tagContent),
-			Complete: true,
+			Type:      TokenText,
+			Start:     t.tagStartPos,
+			End:       t.tagStartPos + len(tagContent),
+			Complete:  true,
+			textified: TextifyDisallowed,
 		})
 		return
 	}
@@ -367,31 +855,19 @@ func (t *StreamXmlTokenizer) parseAndEmitTag(tagContent string) {
 			End:      currentPos + 1,
 			Complete: true,
 		})
-		currentPos++
 	}
 
 	// Emit element name
 	t.pendingTokens = append(t.pendingTokens, &Token{
 		Type:     TokenElementName,
-		Start:    currentPos,
-		End:      currentPos + len(elementName),
+		Start:    innerStart,
+		End:      innerStart + len(elementName),
 		Complete: true,
 	})
-	currentPos += len(elementName)
 
 	// Parse and emit attributes if present
 	if restOfTag != "" {
-		// Calculate position offset
-		// We need to account for spaces between element name and attributes
-		tagContentStart := t.tagStartPos + 1 // Skip <
-		if isClosing {
-			tagContentStart++ // Skip /
-		}
-		attrStartInTag := strings.Index(tagContent[tagContentStart-t.tagStartPos:], restOfTag)
-		if attrStartInTag >= 0 {
-			currentPos = tagContentStart + attrStartInTag
-			t.parseAndEmitAttributes(restOfTag, currentPos)
-		}
+		t.parseAndEmitAttributes(restOfTag, innerStart+restStart)
 	}
 
 	// Emit / for self-closing tag
@@ -421,10 +897,9 @@ func (t *StreamXmlTokenizer) parseAndEmitAttributes(attrStr string, startPos int
 
 	for i < len(attrStr) {
 		// Skip whitespace
-		for i < len(attrStr) && unicode.IsSpace(rune(attrStr[i])) {
-			i++
-			currentPos++
-		}
+		next := skipSpace(attrStr, i)
+		currentPos += next - i
+		i = next
 
 		if i >= len(attrStr) {
 			break
@@ -432,7 +907,7 @@ func (t *StreamXmlTokenizer) parseAndEmitAttributes(attrStr string, startPos int
 
 		// Find attribute name
 		nameStart := i
-		for i < len(attrStr) && attrStr[i] != '=' && !unicode.IsSpace(rune(attrStr[i])) {
+		for i < len(attrStr) && attrStr[i] != '=' && !isSpaceAt(attrStr, i) {
 			i++
 		}
 
@@ -452,10 +927,9 @@ func (t *StreamXmlTokenizer) parseAndEmitAttributes(attrStr string, startPos int
 		currentPos += nameLen
 
 		// Skip whitespace to =
-		for i < len(attrStr) && unicode.IsSpace(rune(attrStr[i])) {
-			i++
-			currentPos++
-		}
+		next = skipSpace(attrStr, i)
+		currentPos += next - i
+		i = next
 
 		if i >= len(attrStr) || attrStr[i] != '=' {
 			break
@@ -472,13 +946,21 @@ func (t *StreamXmlTokenizer) parseAndEmitAttributes(attrStr string, startPos int
 		currentPos++
 
 		// Skip whitespace after =
-		for i < len(attrStr) && unicode.IsSpace(rune(attrStr[i])) {
-			i++
-			currentPos++
-		}
-
-		if i >= len(attrStr) {
-			break
+		next = skipSpace(attrStr, i)
+		skippedSpace := next > i
+		currentPos += next - i
+		i = next
+
+		if i >= len(attrStr) || (skippedSpace && looksLikeAttribute(attrStr[i:])) {
+			// Nothing usable follows =, emit an empty value and let the
+			// next attribute (if any) be parsed on its own
+			t.pendingTokens = append(t.pendingTokens, &Token{
+				Type:     TokenAttributeValue,
+				Start:    currentPos,
+				End:      currentPos,
+				Complete: true,
+			})
+			continue
 		}
 
 		// Parse value
@@ -507,9 +989,10 @@ func (t *StreamXmlTokenizer) parseAndEmitAttributes(attrStr string, startPos int
 				currentPos++
 			}
 		} else {
-			// Value without quotes
+			// Value without quotes runs to the next whitespace or the tag end,
+			// so a=b=c has the value b=c
 			valueStart := i
-			for i < len(attrStr) && !unicode.IsSpace(rune(attrStr[i])) {
+			for i < len(attrStr) && !isSpaceAt(attrStr, i) {
 				i++
 			}
 			valueLen = i - valueStart
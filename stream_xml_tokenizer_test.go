@@ -15,7 +15,11 @@
 package streamxml
 
 import (
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"unicode/utf8"
 )
 
 // Helper function to collect all tokens from the tokenizer
@@ -299,6 +303,37 @@ func TestTokenizeAttributesWithoutQuotes(t *testing.T) {
 	}
 }
 
+// TestTokenizeUnquotedValueWithEquals tests that an unquoted value keeps internal '=' up to whitespace or the tag end
+func TestTokenizeUnquotedValueWithEquals(t *testing.T) {
+	inputs := map[string][]string{
+		`<tag a=b=c d="e">`: {"a", "b=c", "d", "e"},
+		`<tag a=b=c/>`:      {"a", "b=c"},
+		`<tag x==y k=v==>`:  {"x", "=y", "k", "v=="},
+	}
+
+	for input, expected := range inputs {
+		tokenizer := NewStreamXmlTokenizer()
+		tokenizer.Append(input)
+
+		var values []string
+		equals := 0
+		for _, token := range tokenizer.Dump() {
+			switch token.Type {
+			case TokenAttributeName, TokenAttributeValue:
+				values = append(values, token.Value)
+			case TokenEquals:
+				equals++
+			}
+		}
+		if strings.Join(values, " ") != strings.Join(expected, " ") {
+			t.Errorf("%s: expected %q, got %q", input, expected, values)
+		}
+		if equals != len(expected)/2 {
+			t.Errorf("%s: expected %d equals tokens, got %d", input, len(expected)/2, equals)
+		}
+	}
+}
+
 func TestTokenizeCompleteXmlDocument(t *testing.T) {
 	tokenizer := NewStreamXmlTokenizer()
 	tokenizer.Append("<root><child>text content</child></root>")
@@ -632,11 +667,8 @@ func TestTokenizeWhitespaceInTags(t *testing.T) {
 		if token.Type == TokenElementName {
 			foundElementName = true
 			name := getTokenValue(tokenizer, &token)
-			// The tokenizer extracts the trimmed element name from the tag
-			// The actual implementation extracts "  t" due to parsing logic
-			// This is acceptable behavior - we just verify an element name token exists
-			if name == "" {
-				t.Error("Element name should not be empty")
+			if name != "tag" {
+				t.Errorf("Expected element name 'tag', got %q", name)
 			}
 		}
 	}
@@ -646,6 +678,38 @@ func TestTokenizeWhitespaceInTags(t *testing.T) {
 	}
 }
 
+// TestTokenizeMixedWhitespaceInTags tests that tabs, newlines and runs of
+// spaces around names and attributes keep every token's offsets exact
+func TestTokenizeMixedWhitespaceInTags(t *testing.T) {
+	inputs := map[string][]string{
+		"<tool\tname=\"a\"\t\tid='1'>":           {"tool", "name", "a", "id", "1"},
+		"<\ttool  \t name\t=\t\"a b\"\n\tx=y\t>": {"tool", "name", "a b", "x", "y"},
+		"< \t tool\tk = 'v'\t/>":                 {"tool", "k", "v"},
+		"</\ttool\t>":                            {"tool"},
+		"<tool\u3000name=\"a\"\u00a0id=\"2\">":   {"tool", "name", "a", "id", "2"},
+	}
+
+	for input, expected := range inputs {
+		for _, size := range []int{1, len(input)} {
+			tokenizer := NewStreamXmlTokenizer()
+			for i := 0; i < len(input); i += size {
+				tokenizer.Append(input[i:min(i+size, len(input))])
+			}
+
+			var values []string
+			for _, token := range collectTokens(tokenizer) {
+				switch token.Type {
+				case TokenElementName, TokenAttributeName, TokenAttributeValue:
+					values = append(values, getTokenValue(tokenizer, &token))
+				}
+			}
+			if strings.Join(values, "|") != strings.Join(expected, "|") {
+				t.Errorf("%q (chunk size %d): expected %q, got %q", input, size, expected, values)
+			}
+		}
+	}
+}
+
 func TestTokenizeAttributeEdgeCases(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -850,6 +914,32 @@ func TestCompleteFlag(t *testing.T) {
 	}
 }
 
+// TestCloseCompletesTrailingText tests trailing text completeness before and after Close
+func TestCloseCompletesTrailingText(t *testing.T) {
+	tokenizer := NewStreamXmlTokenizer()
+	tokenizer.Append("<a>x</a>Hello")
+
+	tokens := tokenizer.Dump()
+	last := tokens[len(tokens)-1]
+	if last.Type != TokenText || last.Value != "Hello" || last.Complete {
+		t.Errorf("expected incomplete trailing text before Close, got %+v", last)
+	}
+
+	tokenizer.Append(" World")
+	tokenizer.Close()
+	tokens = tokenizer.Dump()
+	if len(tokens) != 1 || tokens[0].Value != " World" || !tokens[0].Complete {
+		t.Errorf("expected complete trailing text after Close, got %+v", tokens)
+	}
+
+	// Text followed by a tag is complete either way
+	tokenizer = NewStreamXmlTokenizer()
+	tokenizer.Append("Hello <a>")
+	if token := tokenizer.NextToken(); token.Type != TokenText || !token.Complete {
+		t.Errorf("expected complete text before a tag, got %+v", token)
+	}
+}
+
 func TestTokenizeComplexDocument(t *testing.T) {
 	tokenizer := NewStreamXmlTokenizer()
 	xml := `<root attr="value">
@@ -889,3 +979,394 @@ func TestTokenizeComplexDocument(t *testing.T) {
 		}
 	}
 }
+
+func TestPendingTokens(t *testing.T) {
+	tokenizer := NewStreamXmlTokenizer()
+	tokenizer.Append(`<tool a="1">`)
+
+	if pending := tokenizer.PendingTokens(); len(pending) != 0 {
+		t.Fatalf("Expected no pending tokens before tokenizing, got %d", len(pending))
+	}
+
+	// The first NextToken parses the whole tag and returns '<'
+	first := tokenizer.NextToken()
+	if first == nil || first.Type != TokenOpenBracket {
+		t.Fatalf("Expected TokenOpenBracket, got %v", first)
+	}
+
+	pending := tokenizer.PendingTokens()
+	expected := []TokenType{TokenElementName, TokenAttributeName, TokenEquals, TokenAttributeValue, TokenCloseBracket}
+	if len(pending) != len(expected) {
+		t.Fatalf("Expected %d pending tokens, got %d", len(expected), len(pending))
+	}
+	for i, tokenType := range expected {
+		if pending[i].Type != tokenType {
+			t.Errorf("Pending token %d: expected %v, got %v", i, tokenType, pending[i].Type)
+		}
+	}
+	if value := getTokenValue(tokenizer, &pending[3]); value != "1" {
+		t.Errorf("Expected pending attribute value '1', got %q", value)
+	}
+
+	// Inspecting does not consume
+	next := tokenizer.NextToken()
+	if next == nil || next.Type != TokenElementName {
+		t.Errorf("Expected TokenElementName after inspection, got %v", next)
+	}
+	if len(tokenizer.PendingTokens()) != len(expected)-1 {
+		t.Errorf("Expected %d pending tokens after one more NextToken", len(expected)-1)
+	}
+}
+
+func TestTokenizeMultibyteRuneSplitAcrossAppends(t *testing.T) {
+	tokenizer := NewStreamXmlTokenizer()
+	data := "café ☕"
+
+	var texts []string
+	for i := 0; i < len(data); i++ {
+		tokenizer.Append(data[i : i+1])
+		for _, token := range collectTokens(tokenizer) {
+			value := getTokenValue(tokenizer, &token)
+			if !utf8.ValidString(value) {
+				t.Errorf("Token value %q split a multibyte rune", value)
+			}
+			texts = append(texts, value)
+		}
+	}
+
+	if joined := strings.Join(texts, ""); joined != data {
+		t.Errorf("Expected text %q, got %q", data, joined)
+	}
+}
+
+func TestTokenizeMultibyteNamesAndWhitespace(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected map[TokenType][]string
+	}{
+		{
+			// 'Å' is encoded as C3 85; the 0x85 byte must not be read as NEL whitespace
+			name:  "name with byte 0x85",
+			input: "<Åtag v=Åb>",
+			expected: map[TokenType][]string{
+				TokenElementName:    {"Åtag"},
+				TokenAttributeName:  {"v"},
+				TokenAttributeValue: {"Åb"},
+			},
+		},
+		{
+			name:  "ideographic space between attributes",
+			input: "<tool　a=\"1\"　b=2>",
+			expected: map[TokenType][]string{
+				TokenElementName:    {"tool"},
+				TokenAttributeName:  {"a", "b"},
+				TokenAttributeValue: {"1", "2"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenizer := NewStreamXmlTokenizer()
+			tokenizer.Append(tt.input)
+
+			got := make(map[TokenType][]string)
+			for _, token := range collectTokens(tokenizer) {
+				if _, ok := tt.expected[token.Type]; ok {
+					got[token.Type] = append(got[token.Type], getTokenValue(tokenizer, &token))
+				}
+			}
+			for tokenType, values := range tt.expected {
+				if strings.Join(got[tokenType], ",") != strings.Join(values, ",") {
+					t.Errorf("Token type %v: expected %q, got %q", tokenType, values, got[tokenType])
+				}
+			}
+		})
+	}
+}
+
+func TestTokenizeIncompleteTagSplitInRune(t *testing.T) {
+	tokenizer := NewStreamXmlTokenizer()
+	tokenizer.Append("<caf\xc3")
+
+	tokens := collectTokens(tokenizer)
+	if len(tokens) != 1 || tokens[0].Type != TokenIncomplete {
+		t.Fatalf("Expected one incomplete token, got %v", tokens)
+	}
+	if value := getTokenValue(tokenizer, &tokens[0]); value != "<caf" {
+		t.Errorf("Expected incomplete value '<caf', got %q", value)
+	}
+
+	tokenizer.Append("\xa9>")
+	for _, token := range collectTokens(tokenizer) {
+		if token.Type == TokenElementName {
+			if value := getTokenValue(tokenizer, &token); value != "café" {
+				t.Errorf("Expected element name 'café', got %q", value)
+			}
+		}
+	}
+}
+
+func TestTokenTypeString(t *testing.T) {
+	if s := TokenAttributeValue.String(); s != "AttributeValue" {
+		t.Errorf("Expected 'AttributeValue', got %q", s)
+	}
+	if s := TokenType(99).String(); s != "TokenType(99)" {
+		t.Errorf("Expected 'TokenType(99)', got %q", s)
+	}
+}
+
+func TestDump(t *testing.T) {
+	tokenizer := NewStreamXmlTokenizer()
+	tokenizer.Append("Hi <tool a=\"1\" b=x><!-- c -->body<br/></tool> bye <par")
+
+	var lines []string
+	for _, token := range tokenizer.Dump() {
+		lines = append(lines, fmt.Sprintf("%s %q %v", token.Type, token.Value, token.Complete))
+	}
+
+	expected := []string{
+		`Text "Hi " true`,
+		`OpenBracket "<" true`,
+		`ElementName "tool" true`,
+		`AttributeName "a" true`,
+		`Equals "=" true`,
+		`AttributeValue "1" true`,
+		`AttributeName "b" true`,
+		`Equals "=" true`,
+		`AttributeValue "x" true`,
+		`CloseBracket ">" true`,
+		`Comment "<!-- c -->" true`,
+		`Text "body" true`,
+		`OpenBracket "<" true`,
+		`ElementName "br" true`,
+		`Slash "/" true`,
+		`CloseBracket ">" true`,
+		`OpenBracket "<" true`,
+		`Slash "/" true`,
+		`ElementName "tool" true`,
+		`CloseBracket ">" true`,
+		`Text " bye " true`,
+		`Incomplete "<par" false`,
+	}
+	if got, want := strings.Join(lines, "\n"), strings.Join(expected, "\n"); got != want {
+		t.Errorf("Unexpected dump:\n%s\nexpected:\n%s", got, want)
+	}
+
+	if rest := tokenizer.Dump(); len(rest) != 0 {
+		t.Errorf("Expected Dump to drain all tokens, got %d more", len(rest))
+	}
+}
+
+func TestValue(t *testing.T) {
+	config := DefaultConfig()
+	config.BufferCleanupThreshold = 16
+	tokenizer := NewStreamXmlTokenizerWithConfig(config)
+	tokenizer.Append("<element-with-a-long-name>hello<i")
+
+	var tokens []Token
+	for token := tokenizer.NextToken(); token != nil; token = tokenizer.NextToken() {
+		value, ok := tokenizer.Value(*token)
+		if expected := getTokenValue(tokenizer, token); !ok || value != expected {
+			t.Errorf("Expected value %q, got %q (ok=%v)", expected, value, ok)
+		}
+		tokens = append(tokens, *token)
+	}
+	if len(tokens) != 5 || tokens[3].Type != TokenText || tokens[4].Type != TokenIncomplete {
+		t.Fatalf("Expected 5 tokens ending with text and an incomplete tag, got %v", tokens)
+	}
+
+	if _, ok := tokenizer.Value(Token{Start: 5, End: 500}); ok {
+		t.Error("Expected out-of-range token to be rejected")
+	}
+	if _, ok := tokenizer.Value(Token{Start: 5, End: 2}); ok {
+		t.Error("Expected token with End before Start to be rejected")
+	}
+
+	// Cleanup drops the consumed tag and text and shifts the buffer
+	tokenizer.Append(">")
+	if tokenizer.GetBuffer() != "<i>" {
+		t.Fatalf("Expected buffer cleanup to keep only the open tag, got %q", tokenizer.GetBuffer())
+	}
+
+	if _, ok := tokenizer.Value(tokens[1]); ok {
+		t.Error("Expected token in trimmed region to be rejected")
+	}
+	if _, ok := tokenizer.Value(tokens[3]); ok {
+		t.Error("Expected returned text in trimmed region to be rejected")
+	}
+	if value, ok := tokenizer.Value(tokens[4]); !ok || value != "<i" {
+		t.Errorf("Expected '<i' after the buffer shifted, got %q (ok=%v)", value, ok)
+	}
+}
+
+// TestSnapshotConcurrentValues tests resolving tokens from several goroutines
+// while the tokenizer keeps receiving data; run with -race
+func TestSnapshotConcurrentValues(t *testing.T) {
+	tokenizer := NewStreamXmlTokenizer()
+	tokenizer.Append(`<tool name="search">query</tool> text <b>x</b>`)
+
+	var tokens []Token
+	for token := tokenizer.NextToken(); token != nil; token = tokenizer.NextToken() {
+		tokens = append(tokens, *token)
+	}
+	view := tokenizer.Snapshot()
+	expected := make([]string, len(tokens))
+	for i, token := range tokens {
+		expected[i], _ = view.Value(token)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				for i, token := range tokens {
+					if value, ok := view.Value(token); !ok || value != expected[i] {
+						t.Errorf("token %d: expected %q, got %q, %v", i, expected[i], value, ok)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for n := 0; n < 100; n++ {
+		tokenizer.Append("<more>data</more>")
+		for tokenizer.NextToken() != nil {
+		}
+	}
+	wg.Wait()
+
+	if view.Buffer() != `<tool name="search">query</tool> text <b>x</b>` {
+		t.Errorf("expected the snapshot to be unchanged, got %q", view.Buffer())
+	}
+	if value, _ := view.Value(tokens[2]); value != "name" {
+		t.Errorf("expected 'name', got %q", value)
+	}
+}
+
+func TestNextTokenValue(t *testing.T) {
+	input := `Hi <tool name="a" x=1>body</tool><done/> tail`
+
+	expected := NewStreamXmlTokenizer()
+	expected.Append(input)
+	tokenizer := NewStreamXmlTokenizer()
+	tokenizer.Append(input)
+
+	for {
+		want := expected.NextToken()
+		token, value := tokenizer.NextTokenValue()
+		if want == nil {
+			if token != nil || value != "" {
+				t.Errorf("Expected no token, got %v %q", token, value)
+			}
+			break
+		}
+		if token == nil || token.Type != want.Type || token.Start != want.Start || token.End != want.End {
+			t.Fatalf("Expected token %v, got %v", want, token)
+		}
+		if wantValue := getTokenValue(expected, want); value != wantValue {
+			t.Errorf("Expected value %q, got %q", wantValue, value)
+		}
+	}
+}
+
+// TestBufferCompactionBounded streams 10MB in 4KB chunks and checks that the
+// buffer stays within a small multiple of the cleanup threshold
+func TestBufferCompactionBounded(t *testing.T) {
+	const total = 10 * 1024 * 1024
+	const chunkSize = 4096
+
+	streams := map[string]string{
+		"text":  "plain text without any tags. ",
+		"mixed": `some text <tool name="x">body</tool> `,
+	}
+
+	for name, unit := range streams {
+		input := strings.Repeat(unit, total/len(unit)+1)[:total]
+		tokenizer := NewStreamXmlTokenizer()
+		threshold := tokenizer.bufferCleanupThreshold
+		bound := chunkSize + 4*threshold
+
+		resolved := 0
+		for i := 0; i < len(input); i += chunkSize {
+			if err := tokenizer.Append(input[i:min(i+chunkSize, len(input))]); err != nil {
+				t.Fatalf("%s: unexpected error at %d: %v", name, i, err)
+			}
+			if n := len(tokenizer.GetBuffer()); n > bound {
+				t.Fatalf("%s: buffer grew to %d bytes at %d, expected at most %d", name, n, i, bound)
+			}
+			for token := tokenizer.NextToken(); token != nil; token = tokenizer.NextToken() {
+				if _, ok := tokenizer.Value(*token); !ok {
+					t.Fatalf("%s: token %v no longer resolvable", name, token)
+				}
+				resolved++
+			}
+		}
+		if tokenizer.discarded == 0 || resolved == 0 {
+			t.Errorf("%s: expected compaction and tokens, discarded %d, tokens %d", name, tokenizer.discarded, resolved)
+		}
+	}
+}
+
+func TestTokenizeCData(t *testing.T) {
+	input := `<a>x<![CDATA[if a < b && c > "d" { <tool/> }]]]>y</a>`
+	// Every split point, including inside both delimiters
+	for split := 0; split <= len(input); split++ {
+		tokenizer := NewStreamXmlTokenizer()
+		var tokens []ResolvedToken
+		for _, chunk := range []string{input[:split], input[split:]} {
+			tokenizer.Append(chunk)
+			for _, token := range tokenizer.Dump() {
+				if token.Type != TokenIncomplete {
+					tokens = append(tokens, token)
+				}
+			}
+		}
+		// Text arrives in pieces, so join consecutive text tokens
+		var lines []string
+		for i := 0; i < len(tokens); i++ {
+			value := tokens[i].Value
+			for tokens[i].Type == TokenText && i+1 < len(tokens) && tokens[i+1].Type == TokenText {
+				i++
+				value += tokens[i].Value
+			}
+			lines = append(lines, fmt.Sprintf("%s %q", tokens[i].Type, value))
+		}
+
+		expected := []string{
+			`OpenBracket "<"`,
+			`ElementName "a"`,
+			`CloseBracket ">"`,
+			`Text "x"`,
+			`CData "<![CDATA[if a < b && c > \"d\" { <tool/> }]]]>"`,
+			`Text "y"`,
+			`OpenBracket "<"`,
+			`Slash "/"`,
+			`ElementName "a"`,
+			`CloseBracket ">"`,
+		}
+		if got, want := strings.Join(lines, "\n"), strings.Join(expected, "\n"); got != want {
+			t.Errorf("split at %d: unexpected tokens:\n%s\nexpected:\n%s", split, got, want)
+		}
+	}
+}
+
+func TestTokenizeCDataIncomplete(t *testing.T) {
+	tokenizer := NewStreamXmlTokenizer()
+	tokenizer.Append("<![CDA")
+	if tokens := tokenizer.Dump(); len(tokens) != 0 {
+		t.Errorf("expected no tokens for a partial CDATA start, got %+v", tokens)
+	}
+	tokenizer.Append("TA[a <b> ]]")
+	if tokens := tokenizer.Dump(); len(tokens) != 0 {
+		t.Errorf("expected no tokens before the terminator, got %+v", tokens)
+	}
+	tokenizer.Append(">")
+	tokens := tokenizer.Dump()
+	if len(tokens) != 1 || tokens[0].Type != TokenCData || tokens[0].Value != "<![CDATA[a <b> ]]>" {
+		t.Errorf("expected one complete CDATA token, got %+v", tokens)
+	}
+}